@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
-	"github.com/tomatopunk/agent-runtime/internal/runtime"
 	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
 )
 
 var deleteCmd = &cobra.Command{
@@ -13,15 +15,33 @@ var deleteCmd = &cobra.Command{
 	RunE:  runDelete,
 }
 
-var deletePluginID string
+var (
+	deletePluginID string
+	deleteSelector string
+)
 
 func init() {
-	deleteCmd.Flags().StringVar(&deletePluginID, "plugin-id", "", "plugin ID (required)")
-	_ = deleteCmd.MarkFlagRequired("plugin-id")
+	deleteCmd.Flags().StringVar(&deletePluginID, "plugin-id", "", "plugin ID (or use --selector)")
+	addSelectorFlag(deleteCmd, &deleteSelector)
 }
 
 func runDelete(cmd *cobra.Command, _ []string) error {
-	return runtime.New(mustRoot(cmd)).Delete(context.Background(), deletePluginID)
+	rt := runtime.New(mustRoot(cmd))
+	ids, err := targetPlugins(rt, deletePluginID, deleteSelector)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := rt.Delete(context.Background(), id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		if deleteSelector != "" {
+			fmt.Println(id)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func init() { rootCmd.AddCommand(deleteCmd) }
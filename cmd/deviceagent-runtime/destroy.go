@@ -2,19 +2,35 @@ package main
 
 import (
 	"context"
+	"fmt"
 
-	"github.com/tomatopunk/agent-runtime/internal/runtime"
 	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/labels"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
 )
 
 var destroyCmd = &cobra.Command{
 	Use:   "destroy",
-	Short: "Stop and remove all plugins",
+	Short: "Stop and remove all plugins (or those matching --selector)",
 	RunE:  runDestroy,
 }
 
+var destroySelector string
+
+func init() {
+	addSelectorFlag(destroyCmd, &destroySelector)
+}
+
 func runDestroy(cmd *cobra.Command, _ []string) error {
-	return runtime.New(mustRoot(cmd)).Destroy(context.Background())
+	sel, err := labels.ParseSelector(destroySelector)
+	if err != nil {
+		return err
+	}
+	// No -l destroys everything; a -l without terms is more likely a mistake than a request for that.
+	if cmd.Flags().Changed("selector") && sel.Empty() {
+		return fmt.Errorf("selector %q has no terms", destroySelector)
+	}
+	return runtime.New(mustRoot(cmd)).Destroy(context.Background(), sel)
 }
 
 func init() { rootCmd.AddCommand(destroyCmd) }
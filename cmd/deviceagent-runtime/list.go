@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
-	"github.com/tomatopunk/agent-runtime/internal/runtime"
 	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/labels"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
 )
 
 var listCmd = &cobra.Command{
@@ -16,27 +21,138 @@ var listCmd = &cobra.Command{
 	RunE:  runList,
 }
 
-var listFormat string
+var (
+	listFormat   string
+	listSelector string
+)
+
+const customColumnsPrefix = "custom-columns="
 
 func init() {
-	listCmd.Flags().StringVar(&listFormat, "format", "text", "output format: text | json")
+	listCmd.Flags().StringVar(&listFormat, "format", "text", "output format: text | json | wide | custom-columns=HEADER:field,... (fields: plugin_id, backend, status, pid, plugin_version, device_id, host_type, work_dir, labels, labels.KEY)")
+	addSelectorFlag(listCmd, &listSelector)
 }
 
 func runList(cmd *cobra.Command, _ []string) error {
+	sel, err := labels.ParseSelector(listSelector)
+	if err != nil {
+		return err
+	}
+	var columns []column
+	switch {
+	case listFormat == "text", listFormat == "json":
+	case listFormat == "wide":
+		columns = wideColumns
+	case strings.HasPrefix(listFormat, customColumnsPrefix):
+		if columns, err = parseColumns(strings.TrimPrefix(listFormat, customColumnsPrefix)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid --format %q (want text | json | wide | custom-columns=...)", listFormat)
+	}
 	rt := runtime.New(mustRoot(cmd))
-	list, err := rt.List(context.Background())
+	all, err := rt.List(context.Background())
 	if err != nil {
 		return err
 	}
+	list := make([]backend.InstanceInfo, 0, len(all))
+	for _, i := range all {
+		if sel.Matches(i.Labels) {
+			list = append(list, i)
+		}
+	}
 	if listFormat == "json" {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		return enc.Encode(list)
 	}
+	if columns == nil {
+		for _, i := range list {
+			fmt.Printf("%s\t%s\t%s\t%d\n", i.PluginID, i.Backend, i.Status, i.Pid)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	headers := make([]string, len(columns))
+	for n, c := range columns {
+		headers[n] = c.header
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
 	for _, i := range list {
-		fmt.Printf("%s\t%s\t%s\t%d\n", i.PluginID, i.Backend, i.Status, i.Pid)
+		cells := make([]string, len(columns))
+		for n, c := range columns {
+			if cells[n] = c.field(i); cells[n] == "" {
+				cells[n] = "<none>"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// column is one column of the wide and custom-columns list output.
+type column struct {
+	header string
+	field  func(backend.InstanceInfo) string
+}
+
+var wideColumns = mustColumns("PLUGIN ID:plugin_id,BACKEND:backend,STATUS:status,PID:pid,VERSION:plugin_version,DEVICE:device_id,LABELS:labels")
+
+func mustColumns(spec string) []column {
+	c, err := parseColumns(spec)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// parseColumns parses HEADER:field pairs; labels.KEY selects a single label.
+func parseColumns(spec string) ([]column, error) {
+	var out []column
+	for _, part := range strings.Split(spec, ",") {
+		header, name, ok := strings.Cut(part, ":")
+		if !ok || header == "" {
+			return nil, fmt.Errorf("invalid column %q: want HEADER:field", part)
+		}
+		f, err := columnField(strings.TrimPrefix(name, "."))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, column{header: header, field: f})
+	}
+	return out, nil
+}
+
+func columnField(name string) (func(backend.InstanceInfo) string, error) {
+	if key, ok := strings.CutPrefix(name, "labels."); ok {
+		return func(i backend.InstanceInfo) string { return i.Labels[key] }, nil
+	}
+	switch name {
+	case "plugin_id":
+		return func(i backend.InstanceInfo) string { return i.PluginID }, nil
+	case "backend":
+		return func(i backend.InstanceInfo) string { return i.Backend }, nil
+	case "status":
+		return func(i backend.InstanceInfo) string { return i.Status }, nil
+	case "pid":
+		return func(i backend.InstanceInfo) string {
+			if i.Pid == 0 {
+				return ""
+			}
+			return strconv.Itoa(i.Pid)
+		}, nil
+	case "plugin_version":
+		return func(i backend.InstanceInfo) string { return i.PluginVersion }, nil
+	case "device_id":
+		return func(i backend.InstanceInfo) string { return i.DeviceID }, nil
+	case "host_type":
+		return func(i backend.InstanceInfo) string { return i.HostType }, nil
+	case "work_dir":
+		return func(i backend.InstanceInfo) string { return i.WorkDir }, nil
+	case "labels":
+		return func(i backend.InstanceInfo) string { return labels.Format(i.Labels) }, nil
 	}
-	return nil
+	return nil, fmt.Errorf("unknown column field %q", name)
 }
 
 func init() { rootCmd.AddCommand(listCmd) }
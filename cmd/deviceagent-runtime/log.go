@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/spf13/cobra"
 	"github.com/tomatopunk/agent-runtime/internal/backend"
 	"github.com/tomatopunk/agent-runtime/internal/runtime"
-	"github.com/spf13/cobra"
 )
 
 var logCmd = &cobra.Command{
@@ -19,17 +24,81 @@ var logCmd = &cobra.Command{
 var logPluginID string
 var logFormat string
 var logLength int
+var logSince string
+var logUntil string
+var logFollow bool
+var logUntilExit bool
+var logLevel string
+var logGrep string
+var logFields []string
 
 func init() {
 	logCmd.Flags().StringVar(&logPluginID, "plugin-id", "", "plugin ID (required)")
 	logCmd.Flags().StringVar(&logFormat, "format", "text", "output format: text | json")
 	logCmd.Flags().IntVar(&logLength, "length", 0, "max lines (0=all)")
+	logCmd.Flags().StringVar(&logSince, "since", "", "only lines at or after this time (RFC3339, or a duration like 10m meaning 10m ago)")
+	logCmd.Flags().StringVar(&logUntil, "until", "", "only lines at or before this time (RFC3339 or duration)")
+	logCmd.Flags().BoolVarP(&logFollow, "follow", "f", false, "stream new lines as they are written")
+	logCmd.Flags().BoolVar(&logUntilExit, "until-exit", false, "with --follow, stop once the plugin has exited")
+	logCmd.Flags().StringVar(&logLevel, "level", "", "only lines with a parsed level at or above this (trace|debug|info|warn|error|fatal)")
+	logCmd.Flags().StringVar(&logGrep, "grep", "", "only lines whose message matches this regular expression")
+	logCmd.Flags().StringArrayVar(&logFields, "field", nil, "only lines whose parsed field KEY equals VALUE (KEY=VALUE, repeatable)")
 	_ = logCmd.MarkFlagRequired("plugin-id")
 }
 
+// parseLogTime accepts an RFC3339 timestamp or a duration relative to now.
+func parseLogTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return &t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q: want RFC3339 or a duration", s)
+	}
+	t := time.Now().Add(-d)
+	return &t, nil
+}
+
 func runLog(cmd *cobra.Command, _ []string) error {
+	if logFormat != "text" && logFormat != "json" {
+		return fmt.Errorf("invalid format %q: want text | json", logFormat)
+	}
+	start, err := parseLogTime(logSince)
+	if err != nil {
+		return err
+	}
+	end, err := parseLogTime(logUntil)
+	if err != nil {
+		return err
+	}
+	if logUntilExit && !logFollow {
+		return fmt.Errorf("--until-exit requires --follow")
+	}
+	fields := map[string]string{}
+	for _, kv := range logFields {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --field %q: want KEY=VALUE", kv)
+		}
+		fields[k] = v
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	rt := runtime.New(mustRoot(cmd))
-	r, err := rt.Log(context.Background(), logPluginID, backend.LogOptions{Format: logFormat, Length: logLength})
+	r, err := rt.Log(ctx, logPluginID, backend.LogOptions{
+		Start:     start,
+		End:       end,
+		Format:    logFormat,
+		Length:    logLength,
+		Follow:    logFollow,
+		UntilExit: logUntilExit,
+		Level:     logLevel,
+		Grep:      logGrep,
+		Fields:    fields,
+	})
 	if err != nil {
 		return err
 	}
@@ -8,10 +8,12 @@ import (
 )
 
 func main() {
-	log := logger.New()
-	_ = zap.ReplaceGlobals(log)
+	// Replaced in rootCmd's PersistentPreRunE once --log-* flags are parsed.
+	_ = zap.ReplaceGlobals(logger.New())
 	if err := rootCmd.Execute(); err != nil {
-		log.Error("command failed", zap.Error(err))
+		zap.L().Error("command failed", zap.Error(err))
+		_ = zap.L().Sync()
 		os.Exit(1)
 	}
+	_ = zap.L().Sync()
 }
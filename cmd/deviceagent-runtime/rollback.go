@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Switch a plugin back to a kept earlier version",
+	RunE:  runRollback,
+}
+
+var (
+	rollbackPluginID string
+	rollbackOpts     runtime.UpgradeOptions
+	rollbackHealth   string
+)
+
+func init() {
+	rollbackCmd.Flags().StringVar(&rollbackPluginID, "plugin-id", "", "plugin ID (required)")
+	rollbackCmd.Flags().StringVar(&rollbackOpts.Version, "to-version", "", "version to return to (default: the previous one)")
+	addReadinessFlags(rollbackCmd, &rollbackOpts, &rollbackHealth)
+	_ = rollbackCmd.MarkFlagRequired("plugin-id")
+}
+
+func runRollback(cmd *cobra.Command, _ []string) error {
+	rollbackOpts.HealthCmd = healthArgv(rollbackHealth)
+	rollbackOpts.Launch = shimLauncher(cmd)
+	return runtime.New(mustRoot(cmd)).Rollback(context.Background(), rollbackPluginID, rollbackOpts)
+}
+
+func init() { rootCmd.AddCommand(rollbackCmd) }
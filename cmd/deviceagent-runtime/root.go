@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/logger"
+	"github.com/tomatopunk/agent-runtime/internal/logs"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var rootCmd = &cobra.Command{
@@ -12,10 +17,50 @@ var rootCmd = &cobra.Command{
 	Short: "Unified runtime CLI with binary and runc backends",
 	Long: `Agent invokes this binary only; it does not call runc directly.
 This runtime provides unified logs and list/state semantics; daemon/restart is implemented by the caller.`,
+	PersistentPreRunE: setupLogger,
 }
 
+var (
+	logOpts         logger.Options
+	logFile         string
+	logFileRotation backend.LogRotation
+	logFileMaxFiles int
+	logFileCompress bool
+)
+
 func init() {
 	rootCmd.PersistentFlags().StringP("root", "r", "", "runtime root dir (required)")
+	rootCmd.PersistentFlags().StringVar(&logOpts.Level, "log-level", "info", "runtime log level: debug | info | warn | error")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "write the runtime log to this file (rotated by size, shared by all shims) instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&logFileRotation.MaxSize, "log-file-max-size", "", "rotate --log-file at this size (default 10Mi)")
+	rootCmd.PersistentFlags().IntVar(&logFileMaxFiles, "log-file-max-files", 0, "rotated --log-file segments to keep, 0 for none (default 5)")
+	rootCmd.PersistentFlags().BoolVar(&logFileCompress, "log-file-compress", true, "gzip rotated --log-file segments")
+	rootCmd.PersistentFlags().StringVar(&logOpts.Encoding, "log-encoding", "json", "runtime log encoding: json | console")
+}
+
+// setupLogger replaces the global logger according to the --log-* flags.
+func setupLogger(cmd *cobra.Command, _ []string) error {
+	if logFile != "" {
+		flags := cmd.Root().PersistentFlags()
+		if flags.Changed("log-file-max-files") {
+			logFileRotation.MaxFiles = &logFileMaxFiles
+		}
+		if flags.Changed("log-file-compress") {
+			logFileRotation.Compress = &logFileCompress
+		}
+		// Every shim of every plugin appends to the same file.
+		w, err := logs.NewSharedRotatingWriter(logFile, logFileRotation)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		logOpts.Output = zapcore.AddSync(w)
+	}
+	log, err := logger.Build(logOpts)
+	if err != nil {
+		return err
+	}
+	zap.ReplaceGlobals(log)
+	return nil
 }
 
 // mustRoot returns --root from the root command's PersistentFlags; exits if unset.
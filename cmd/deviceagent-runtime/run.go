@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/image"
+	"github.com/tomatopunk/agent-runtime/internal/labels"
+	"github.com/tomatopunk/agent-runtime/internal/logs"
+	"github.com/tomatopunk/agent-runtime/internal/resources"
 	"github.com/tomatopunk/agent-runtime/internal/runtime"
+	"github.com/tomatopunk/agent-runtime/internal/verify"
 )
 
 var runCmd = &cobra.Command{
@@ -28,9 +35,39 @@ var (
 	runWorkDir       string
 	runExecutable    string
 	runArgs          string
-	runCPU           string
-	runMem           string
 	runEnv           string
+	runResources     resources.Options
+	runIOLimits      string
+	runRlimits       string
+	runLogRotation   backend.LogRotation
+	runLogCompress   bool
+	runLogMaxFiles   int
+	runLogDriver     string
+	runLogLimits     backend.LogLimits
+	runLogOpts       string
+	runLabels        string
+	runImage         string
+	runBaseRootfs    string
+	runSpecPatches   string
+	runSpecFile      string
+	runSecurity      string
+	runDevices       string
+	runMounts        []string
+	runHostDir       bool
+	runSecrets       []string
+	runSecretEnv     []string
+	runEnvFiles      string
+	runEnvPolicy     string
+	runEnvAllow      string
+	runIsolation     backend.Isolation
+	runGroups        string
+	runNamespaces    string
+	runLandlock      bool
+	runLandlockRO    string
+	runLandlockRW    string
+	runDigest        string
+	runSignature     string
+	runLogParser     string
 	runExec          bool // true when we are the re-exec'd shim child (internal)
 )
 
@@ -42,16 +79,66 @@ func init() {
 	runCmd.Flags().StringVar(&runHostName, "host-name", "", "host name (injected as HOST_NAME)")
 	runCmd.Flags().StringVar(&runBackend, "backend", "binary", "backend: binary | runc")
 	runCmd.Flags().StringVar(&runWorkDir, "work-dir", "", "work dir / bundle path (required)")
-	runCmd.Flags().StringVar(&runExecutable, "executable", "", "host path to the binary to run (required)")
+	runCmd.Flags().StringVar(&runExecutable, "executable", "", "host path to the binary to run (required unless --image; with --image, an entrypoint path inside the image)")
+	runCmd.Flags().StringVar(&runImage, "image", "", "runc only: run from a local OCI image layout, oci-layout:<dir>[:<tag>]; --digest/--signature then apply to the image manifest")
+	runCmd.Flags().StringVar(&runBaseRootfs, "base-rootfs", "", "runc only: host dir (e.g. an unpacked busybox) overlaid read-only under the plugin rootfs; without it only the executable, its interpreter and libraries are there, so scripts that run other commands need one (default config.json runc.base_rootfs)")
+	runCmd.Flags().StringVar(&runSpecPatches, "spec-patch", "", "runc only: files applied in order over the generated config.json, comma-separated; a JSON object is a merge patch, an array a JSON patch")
+	runCmd.Flags().StringVar(&runSpecFile, "spec-file", "", "runc only: complete config.json to use verbatim instead of the generated one; it must set up its own mounts and env (no PLUGIN_DATA_DIR, PLUGIN_LOG_DIR or runtime socket mounts are added) and excludes --spec-patch, --device, --mount, --host-dir and secrets")
+	runCmd.Flags().StringVar(&runSecurity, "security-profile", "", "runc only: default | restricted | privileged (default config.json runc.security_profile, else default)")
+	runCmd.Flags().StringVar(&runDevices, "device", "", "runc only: host devices to pass through, comma-separated HOST[:CONTAINER][:PERMS] (PERMS of rwm); HOST may be a glob like /dev/ttyUSB*")
+	runCmd.Flags().StringArrayVar(&runMounts, "mount", nil, "runc only: extra mount, repeatable: type=bind|volume,src=HOST_PATH|VOLUME,dst=PATH[,ro]; bind sources must be in config.json runc.allowed_host_paths, volumes live under <root>/volumes and survive delete")
+	runCmd.Flags().BoolVar(&runHostDir, "host-dir", false, "runc only: bind the host / read-only at /host and set HOST_DIR=/host")
+	runCmd.Flags().StringVar(&runIsolation.User, "user", "", "binary only: run as uid[:gid] or name[:group]")
+	runCmd.Flags().StringVar(&runGroups, "groups", "", "binary only: supplementary groups, comma-separated gids or names")
+	runCmd.Flags().StringVar(&runNamespaces, "namespaces", "", "binary only: new namespaces, comma-separated: mount | pid | ipc | uts | net")
+	runCmd.Flags().StringVar(&runIsolation.Root, "chroot", "", "binary only: prepared dir to chroot into; --executable (and --work-dir, else /) must lie inside it")
+	runCmd.Flags().BoolVar(&runIsolation.NoNewPrivileges, "no-new-privileges", false, "binary only: set no_new_privs so setuid binaries and file capabilities grant nothing")
+	runCmd.Flags().BoolVar(&runIsolation.DropCaps, "drop-caps", false, "binary only: start the plugin with no capabilities, even as root")
+	runCmd.Flags().BoolVar(&runIsolation.DieWithShim, "die-with-shim", false, "binary only: kill the plugin if its shim process dies")
+	runCmd.Flags().BoolVar(&runLandlock, "landlock", false, "binary only: restrict filesystem access with Landlock to the executable, system dirs, --work-dir and the --landlock-ro/-rw paths")
+	runCmd.Flags().StringVar(&runLandlockRO, "landlock-ro", "", "binary only: extra read-only paths for Landlock, comma-separated (implies --landlock)")
+	runCmd.Flags().StringVar(&runLandlockRW, "landlock-rw", "", "binary only: extra read-write data dirs for Landlock, comma-separated (implies --landlock)")
 	runCmd.Flags().StringVar(&runArgs, "args", "", "optional args for the command, comma-separated")
-	runCmd.Flags().StringVar(&runCPU, "cpu", "", "cgroup CPU quota")
-	runCmd.Flags().StringVar(&runMem, "mem", "", "cgroup memory quota")
-	runCmd.Flags().StringVar(&runEnv, "env", "", "env vars, comma-separated KEY=VALUE")
+	runCmd.Flags().StringVar(&runEnv, "env", "", "env vars, comma-separated KEY=VALUE (stored in the plugin state; use --secret for credentials)")
+	runCmd.Flags().StringVar(&runEnvFiles, "env-file", "", "env files read at each start, comma-separated; KEY=VALUE lines, a bare KEY copies the agent's value, # comments (--env wins)")
+	runCmd.Flags().StringVar(&runEnvPolicy, "env-policy", "", "how much of the agent's env the plugin inherits: inherit | clean (PATH only) | allowlist (PATH and --env-allow) (default inherit for binary, clean for runc)")
+	runCmd.Flags().StringVar(&runEnvAllow, "env-allow", "", "agent env vars passed to the plugin, comma-separated names (implies --env-policy allowlist)")
+	runCmd.Flags().StringArrayVar(&runSecrets, "secret", nil, "secret file, repeatable NAME=FILE: read at each start and delivered as $PLUGIN_SECRETS_DIR/NAME (runc: a tmpfs at /run/secrets); only the reference is stored")
+	runCmd.Flags().StringArrayVar(&runSecretEnv, "secret-env", nil, "secret env var, repeatable VAR=FILE: the file's content becomes $VAR at each start; only the reference is stored")
+	runCmd.Flags().StringVar(&runResources.CPU, "cpu", "", "hard CPU limit (cpu.max) in cores, e.g. 0.5 or 500m")
+	runCmd.Flags().StringVar(&runResources.CPUShares, "cpu-shares", "", "relative CPU weight (2-262144)")
+	runCmd.Flags().StringVar(&runResources.CpusetCPUs, "cpuset-cpus", "", "CPUs the plugin may run on, e.g. 0-1,3")
+	runCmd.Flags().StringVar(&runResources.CpusetMems, "cpuset-mems", "", "memory nodes the plugin may use, e.g. 0")
+	runCmd.Flags().StringVar(&runResources.Pids, "pids-limit", "", "max number of processes/threads (pids.max)")
+	runCmd.Flags().StringVar(&runResources.IOWeight, "io-weight", "", "block IO weight (10-1000)")
+	runCmd.Flags().StringVar(&runIOLimits, "io-limit", "", "per-device IO limits, comma-separated DEVICE:rbps=N:wbps=N:riops=N:wiops=N")
+	runCmd.Flags().StringVar(&runResources.Mem, "mem", "", "memory limit (memory.max), e.g. 128Mi, 1Gi, 100MB; runc plugins without any --mem* flag keep the 512Mi default")
+	runCmd.Flags().StringVar(&runResources.MemHigh, "mem-high", "", "memory throttle threshold (memory.high)")
+	runCmd.Flags().StringVar(&runResources.MemSwap, "mem-swap", "", "swap allowed in addition to --mem")
+	runCmd.Flags().StringVar(&runResources.MemMin, "mem-min", "", "guaranteed memory reservation (memory.min)")
+	runCmd.Flags().StringVar(&runRlimits, "rlimit", "", "process rlimits, comma-separated NAME=SOFT[:HARD] (NAME nofile|nproc|core|cpu|as, value a number or unlimited)")
+	runCmd.Flags().StringVar(&runResources.Nice, "nice", "", "nice value (-20..19)")
+	runCmd.Flags().StringVar(&runResources.IONice, "ionice", "", "I/O scheduling CLASS[:LEVEL], CLASS realtime | best-effort | idle, LEVEL 0-7")
+	runCmd.Flags().StringVar(&runResources.Sched, "sched", "", "real-time scheduling policy fifo:PRIO or rr:PRIO (PRIO 1-99)")
+	runCmd.Flags().StringVar(&runResources.CPUAffinity, "cpu-affinity", "", "CPUs the plugin process may run on, e.g. 0-1,3 (runc: the container cpuset unless --cpuset-cpus is set)")
+	runCmd.Flags().StringVar(&runLogRotation.MaxSize, "log-max-size", "", "rotate the plugin log at this size (default 10Mi, or config.json log.max_size)")
+	runCmd.Flags().IntVar(&runLogMaxFiles, "log-max-files", 0, "rotated log segments to keep, 0 for none (default 5, or config.json log.max_files)")
+	runCmd.Flags().BoolVar(&runLogCompress, "log-compress", true, "gzip rotated log segments")
+	runCmd.Flags().Float64Var(&runLogLimits.LinesPerSec, "log-rate-lines", 0, "max log lines per second (0=unlimited)")
+	runCmd.Flags().IntVar(&runLogLimits.LineBurst, "log-burst-lines", 0, "log lines allowed above the rate at once (default: one second's worth, at least 1)")
+	runCmd.Flags().StringVar(&runLogLimits.BytesPerSec, "log-rate-bytes", "", "max log bytes per second, e.g. 64Ki")
+	runCmd.Flags().StringVar(&runLogLimits.ByteBurst, "log-burst-bytes", "", "log bytes allowed above the rate at once (default: one second's worth, at least one line: --log-max-line or 16Ki)")
+	runCmd.Flags().StringVar(&runLogLimits.MaxLineSize, "log-max-line", "", "truncate log lines longer than this, e.g. 4Ki")
+	runCmd.Flags().StringVar(&runLogDriver, "log-driver", "", "log drivers, comma-separated: file | syslog | journald | ring (default file)")
+	runCmd.Flags().StringVar(&runDigest, "digest", "", "expected executable digest, sha256:<hex>; start is refused on mismatch")
+	runCmd.Flags().StringVar(&runSignature, "signature", "", "detached ed25519 signature over the digest string (default <executable>.sig if present), checked against <root>/trusted-keys")
+	runCmd.Flags().StringVar(&runLabels, "label", "", "plugin labels, comma-separated KEY=VALUE (used by -l selectors; runc also sets them as annotations)")
+	runCmd.Flags().StringVar(&runLogOpts, "log-opt", "", "log driver options, comma-separated KEY=VALUE (syslog-address, journald-socket, ring-size)")
+	runCmd.Flags().StringVar(&runLogParser, "log-parser", "", "how `log` extracts level/message/fields: auto | json | logfmt | none | regex:<expr> (default auto)")
 	runCmd.Flags().BoolVar(&runExec, "exec", false, "internal: re-exec'd shim process")
 	_ = runCmd.Flags().MarkHidden("exec")
 	_ = runCmd.MarkFlagRequired("plugin-id")
 	_ = runCmd.MarkFlagRequired("work-dir")
-	_ = runCmd.MarkFlagRequired("executable")
 }
 
 func runRun(cmd *cobra.Command, _ []string) error {
@@ -63,31 +150,18 @@ func runRun(cmd *cobra.Command, _ []string) error {
 		if err != nil {
 			return fmt.Errorf("executable: %w", err)
 		}
-		argv := []string{"/proc/self/exe", "run", "--exec", "-r", root, "--plugin-id", runPluginID, "--backend", runBackend, "--work-dir", runWorkDir, "--executable", runExecutable}
-		if runPluginVersion != "" {
-			argv = append(argv, "--plugin-version", runPluginVersion)
-		}
-		if runDeviceId != "" {
-			argv = append(argv, "--device-id", runDeviceId)
-		}
-		if runHostType != "" {
-			argv = append(argv, "--host-type", runHostType)
-		}
-		if runHostName != "" {
-			argv = append(argv, "--host-name", runHostName)
-		}
-		if runArgs != "" {
-			argv = append(argv, "--args", runArgs)
-		}
-		if runCPU != "" {
-			argv = append(argv, "--cpu", runCPU)
-		}
-		if runMem != "" {
-			argv = append(argv, "--mem", runMem)
-		}
-		if runEnv != "" {
-			argv = append(argv, "--env", runEnv)
-		}
+		argv := []string{"/proc/self/exe", "run", "--exec"}
+		// Forward every flag the caller set (including --root and --log-*), so new flags need no extra plumbing here.
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			if s, ok := f.Value.(pflag.SliceValue); ok {
+				// Repeatable flags go one value at a time; their String() is not re-parseable.
+				for _, v := range s.GetSlice() {
+					argv = append(argv, "--"+f.Name+"="+v)
+				}
+				return
+			}
+			argv = append(argv, "--"+f.Name+"="+f.Value.String())
+		})
 		c := exec.Command(argv[0], argv[1:]...)
 		c.Stdout = os.Stdout
 		c.Stderr = os.Stderr
@@ -97,6 +171,9 @@ func runRun(cmd *cobra.Command, _ []string) error {
 	}
 
 	// We are the shim child: only this process builds runtime state and runs the plugin.
+	if runExecutable == "" && runImage == "" {
+		return fmt.Errorf("--executable or --image is required")
+	}
 	var env []string
 	if runEnv != "" {
 		for _, e := range strings.Split(runEnv, ",") {
@@ -109,22 +186,237 @@ func runRun(cmd *cobra.Command, _ []string) error {
 			args = append(args, strings.TrimSpace(a))
 		}
 	}
+	res := runResources
+	if runIOLimits != "" {
+		for _, l := range strings.Split(runIOLimits, ",") {
+			res.IOLimits = append(res.IOLimits, strings.TrimSpace(l))
+		}
+	}
+	if runRlimits != "" {
+		for _, l := range strings.Split(runRlimits, ",") {
+			res.Rlimits = append(res.Rlimits, strings.TrimSpace(l))
+		}
+	}
+	if err := logs.ValidateParser(runLogParser); err != nil {
+		return err
+	}
+	var logDrivers []string
+	if runLogDriver != "" {
+		for _, d := range strings.Split(runLogDriver, ",") {
+			logDrivers = append(logDrivers, strings.TrimSpace(d))
+		}
+	}
+	logOpts := map[string]string{}
+	if runLogOpts != "" {
+		for _, kv := range strings.Split(runLogOpts, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				return fmt.Errorf("invalid --log-opt %q: want KEY=VALUE", kv)
+			}
+			logOpts[k] = v
+		}
+	}
+	sigFor := runExecutable
+	if runImage != "" {
+		sigFor = ""
+	}
+	signature, err := loadSignature(runSignature, sigFor)
+	if err != nil {
+		return err
+	}
+	workDir, err := absPath(runWorkDir)
+	if err != nil {
+		return err
+	}
+	// With --image, --executable is a path inside the image, not on the host.
+	executable := runExecutable
+	if runImage == "" {
+		if executable, err = absPath(runExecutable); err != nil {
+			return err
+		}
+	}
+	img, err := absImage(runImage)
+	if err != nil {
+		return err
+	}
+	baseRootfs, err := absPath(runBaseRootfs)
+	if err != nil {
+		return err
+	}
+	specFile, err := absPath(runSpecFile)
+	if err != nil {
+		return err
+	}
+	specPatches, err := absPaths(runSpecPatches)
+	if err != nil {
+		return err
+	}
+	var devices []string
+	if runDevices != "" {
+		for _, d := range strings.Split(runDevices, ",") {
+			devices = append(devices, strings.TrimSpace(d))
+		}
+	}
+	var mounts []backend.Mount
+	for _, s := range runMounts {
+		m, err := backend.ParseMount(s)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, m)
+	}
+	envFiles, err := absPaths(runEnvFiles)
+	if err != nil {
+		return err
+	}
+	var envAllow []string
+	if runEnvAllow != "" {
+		for _, name := range strings.Split(runEnvAllow, ",") {
+			envAllow = append(envAllow, strings.TrimSpace(name))
+		}
+	}
+	var secrets []backend.Secret
+	for _, list := range []struct {
+		specs []string
+		env   bool
+	}{{runSecrets, false}, {runSecretEnv, true}} {
+		for _, s := range list.specs {
+			name, file, _ := strings.Cut(s, "=")
+			abs, err := absPath(file)
+			if err != nil {
+				return err
+			}
+			sec, err := backend.ParseSecret(name+"="+abs, list.env)
+			if err != nil {
+				return err
+			}
+			secrets = append(secrets, sec)
+		}
+	}
+	iso := runIsolation
+	if iso.Root, err = absPath(iso.Root); err != nil {
+		return err
+	}
+	if runGroups != "" {
+		for _, g := range strings.Split(runGroups, ",") {
+			iso.Groups = append(iso.Groups, strings.TrimSpace(g))
+		}
+	}
+	if runNamespaces != "" {
+		for _, ns := range strings.Split(runNamespaces, ",") {
+			iso.Namespaces = append(iso.Namespaces, strings.TrimSpace(ns))
+		}
+	}
+	if runLandlock || runLandlockRO != "" || runLandlockRW != "" {
+		iso.Landlock = &backend.LandlockRules{}
+		if iso.Landlock.ReadOnly, err = absPaths(runLandlockRO); err != nil {
+			return err
+		}
+		if iso.Landlock.ReadWrite, err = absPaths(runLandlockRW); err != nil {
+			return err
+		}
+	}
+	pluginLabels, err := labels.Parse(runLabels)
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("log-max-files") {
+		runLogRotation.MaxFiles = &runLogMaxFiles
+	}
+	if cmd.Flags().Changed("log-compress") {
+		runLogRotation.Compress = &runLogCompress
+	}
 	opts := backend.RunOptions{
-		PluginID:      runPluginID,
-		PluginVersion: runPluginVersion,
-		DeviceId:      runDeviceId,
-		HostType:      runHostType,
-		HostName:      runHostName,
-		RootDir:       root,
-		WorkDir:       runWorkDir,
-		Executable:    runExecutable,
-		Args:          args,
-		CPU:           runCPU,
-		Mem:           runMem,
-		Env:           env,
+		PluginID:        runPluginID,
+		PluginVersion:   runPluginVersion,
+		DeviceId:        runDeviceId,
+		HostType:        runHostType,
+		HostName:        runHostName,
+		RootDir:         root,
+		WorkDir:         workDir,
+		Executable:      executable,
+		Image:           img,
+		BaseRootfs:      baseRootfs,
+		SpecPatches:     specPatches,
+		SpecFile:        specFile,
+		SecurityProfile: runSecurity,
+		Devices:         devices,
+		Mounts:          mounts,
+		HostDir:         runHostDir,
+		Secrets:         secrets,
+		EnvFiles:        envFiles,
+		EnvPolicy:       runEnvPolicy,
+		EnvAllow:        envAllow,
+		Isolation:       iso,
+		Args:            args,
+		Env:             env,
+		Resources:       res,
+		LogRotation:     runLogRotation,
+		LogLimits:       runLogLimits,
+		LogDrivers:      logDrivers,
+		LogParser:       runLogParser,
+		LogOpts:         logOpts,
+		Labels:          pluginLabels,
+		Digest:          runDigest,
+		Signature:       signature,
 	}
 	rt := runtime.New(root)
 	return rt.RunAndWait(context.Background(), runBackend, opts)
 }
 
+// absPath makes a host path flag absolute, since upgrade and rollback restart the plugin from
+// another working dir; empty stays empty.
+func absPath(p string) (string, error) {
+	if p == "" {
+		return "", nil
+	}
+	return filepath.Abs(p)
+}
+
+// absImage makes the layout dir of an oci-layout image reference absolute, as absPath does for
+// host paths; empty stays empty.
+func absImage(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	r, err := image.ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if r.Layout, err = filepath.Abs(r.Layout); err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+// absPaths splits a comma-separated list of host paths and makes each absolute.
+func absPaths(list string) ([]string, error) {
+	var out []string
+	if list == "" {
+		return out, nil
+	}
+	for _, p := range strings.Split(list, ",") {
+		abs, err := absPath(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, abs)
+	}
+	return out, nil
+}
+
+// loadSignature reads --signature, or <executable>.sig when the flag is unset and that file exists.
+func loadSignature(path, executable string) (string, error) {
+	if path == "" {
+		if executable == "" {
+			return "", nil
+		}
+		path = executable + ".sig"
+		if _, err := os.Stat(path); err != nil {
+			return "", nil
+		}
+	}
+	return verify.ReadSignature(path)
+}
+
 func init() { rootCmd.AddCommand(runCmd) }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/labels"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
+)
+
+// addSelectorFlag registers -l/--selector on cmd.
+func addSelectorFlag(cmd *cobra.Command, target *string) {
+	cmd.Flags().StringVarP(target, "selector", "l", "", "label selector, e.g. env=prod,tier in (edge,core),!canary")
+}
+
+// targetPlugins resolves the plugins a stop/delete applies to: either --plugin-id or the plugins matching -l.
+func targetPlugins(rt *runtime.Runtime, pluginID, selector string) ([]string, error) {
+	switch {
+	case pluginID != "" && selector != "":
+		return nil, fmt.Errorf("--plugin-id and --selector are mutually exclusive")
+	case pluginID != "":
+		return []string{pluginID}, nil
+	case selector == "":
+		return nil, fmt.Errorf("--plugin-id or --selector is required")
+	}
+	sel, err := labels.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	// A selector of only blanks and commas has no terms and would match every plugin.
+	if sel.Empty() {
+		return nil, fmt.Errorf("selector %q has no terms", selector)
+	}
+	return rt.Select(sel)
+}
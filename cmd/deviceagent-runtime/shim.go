@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
+)
+
+// shimCmd is the detached shim that upgrade and rollback launch: it runs a registered plugin from its meta.
+var shimCmd = &cobra.Command{
+	Use:    "shim",
+	Short:  "internal: run a registered plugin from its meta",
+	Hidden: true,
+	RunE:   runShim,
+}
+
+var shimPluginID string
+
+func init() {
+	shimCmd.Flags().StringVar(&shimPluginID, "plugin-id", "", "plugin ID (required)")
+	_ = shimCmd.MarkFlagRequired("plugin-id")
+}
+
+func runShim(cmd *cobra.Command, _ []string) error {
+	return runtime.New(mustRoot(cmd)).RunRegistered(context.Background(), shimPluginID)
+}
+
+// shimLauncher returns a runtime.Launcher that starts `shim` in its own session, detached from the
+// caller's terminal, forwarding --root and the --log-* flags the caller set.
+func shimLauncher(cmd *cobra.Command) runtime.Launcher {
+	return func(pluginID string) (<-chan error, error) {
+		argv := []string{"shim", "--plugin-id=" + pluginID}
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			if cmd.Root().PersistentFlags().Lookup(f.Name) != nil {
+				argv = append(argv, "--"+f.Name+"="+f.Value.String())
+			}
+		})
+		c := exec.Command("/proc/self/exe", argv...)
+		c.Env = os.Environ()
+		c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := c.Start(); err != nil {
+			return nil, err
+		}
+		exited := make(chan error, 1)
+		go func() {
+			if err := c.Wait(); err != nil {
+				exited <- err
+			}
+			close(exited)
+		}()
+		return exited, nil
+	}
+}
+
+func init() { rootCmd.AddCommand(shimCmd) }
@@ -5,9 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/tomatopunk/agent-runtime/internal/runtime"
 	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
 )
 
 var stateCmd = &cobra.Command{
@@ -37,6 +38,35 @@ func runState(cmd *cobra.Command, _ []string) error {
 		return enc.Encode(info)
 	}
 	fmt.Printf("plugin_id: %s\nbackend: %s\nstatus: %s\npid: %d\n", info.PluginID, info.Backend, info.Status, info.Pid)
+	if info.LogDroppedLines > 0 {
+		fmt.Printf("log_dropped_lines: %d\n", info.LogDroppedLines)
+	}
+	if info.PluginVersion != "" {
+		fmt.Printf("version: %s\n", info.PluginVersion)
+	}
+	if info.Digest != "" {
+		fmt.Printf("digest: %s\n", info.Digest)
+	}
+	if info.SignedBy != "" {
+		fmt.Printf("signed_by: %s\n", info.SignedBy)
+	}
+	if info.Landlock != "" {
+		fmt.Printf("landlock: %s", info.Landlock)
+		if info.LandlockABI > 0 {
+			fmt.Printf(" (abi %d)", info.LandlockABI)
+		}
+		fmt.Println()
+	}
+	if len(info.History) > 0 {
+		fmt.Println("history:")
+		for _, h := range info.History {
+			fmt.Printf("  %s\t%s\t%s", h.Version, h.Status, h.StartedAt.Format(time.RFC3339))
+			if h.Error != "" {
+				fmt.Printf("\t%s", h.Error)
+			}
+			fmt.Println()
+		}
+	}
 	return nil
 }
 
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
-	"github.com/tomatopunk/agent-runtime/internal/runtime"
 	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
 )
 
 var stopCmd = &cobra.Command{
@@ -13,15 +15,33 @@ var stopCmd = &cobra.Command{
 	RunE:  runStop,
 }
 
-var stopPluginID string
+var (
+	stopPluginID string
+	stopSelector string
+)
 
 func init() {
-	stopCmd.Flags().StringVar(&stopPluginID, "plugin-id", "", "plugin ID (required)")
-	_ = stopCmd.MarkFlagRequired("plugin-id")
+	stopCmd.Flags().StringVar(&stopPluginID, "plugin-id", "", "plugin ID (or use --selector)")
+	addSelectorFlag(stopCmd, &stopSelector)
 }
 
 func runStop(cmd *cobra.Command, _ []string) error {
-	return runtime.New(mustRoot(cmd)).Stop(context.Background(), stopPluginID)
+	rt := runtime.New(mustRoot(cmd))
+	ids, err := targetPlugins(rt, stopPluginID, stopSelector)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := rt.Stop(context.Background(), id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		if stopSelector != "" {
+			fmt.Println(id)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func init() { rootCmd.AddCommand(stopCmd) }
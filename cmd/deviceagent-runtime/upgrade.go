@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Switch a plugin to a new executable and version once it passes a preflight run; roll back automatically if it does not become ready",
+	RunE:  runUpgrade,
+}
+
+var (
+	upgradePluginID  string
+	upgradeOpts      runtime.UpgradeOptions
+	upgradeHealth    string
+	upgradeSig       string
+	upgradePreflight bool
+)
+
+// addReadinessFlags registers the flags upgrade and rollback share.
+func addReadinessFlags(cmd *cobra.Command, opts *runtime.UpgradeOptions, health *string) {
+	cmd.Flags().StringVar(health, "health-cmd", "", "command that must exit 0 once the plugin is ready (run via sh -c; PLUGIN_ID and PLUGIN_PID are set)")
+	cmd.Flags().DurationVar(&opts.ReadyTimeout, "ready-timeout", 30*time.Second, "how long the new version has to become ready")
+	cmd.Flags().DurationVar(&opts.MinUptime, "min-uptime", 5*time.Second, "without --health-cmd, the plugin is ready once it has been running this long")
+}
+
+// healthArgv wraps --health-cmd for the shell.
+func healthArgv(health string) []string {
+	if strings.TrimSpace(health) == "" {
+		return nil
+	}
+	return []string{"/bin/sh", "-c", health}
+}
+
+func init() {
+	upgradeCmd.Flags().StringVar(&upgradePluginID, "plugin-id", "", "plugin ID (required)")
+	upgradeCmd.Flags().StringVar(&upgradeOpts.Executable, "executable", "", "host path to the new executable (required)")
+	upgradeCmd.Flags().StringVar(&upgradeOpts.Version, "plugin-version", "", "new plugin version (required)")
+	upgradeCmd.Flags().StringVar(&upgradeOpts.Digest, "digest", "", "expected digest of the new executable, sha256:<hex>")
+	upgradeCmd.Flags().StringVar(&upgradeSig, "signature", "", "detached ed25519 signature of the new executable (default <executable>.sig if present)")
+	upgradeCmd.Flags().BoolVar(&upgradePreflight, "preflight", true, "start the new version as <plugin-id>-upgrade and wait for it to be ready before stopping the running one; disable for plugins that cannot run twice")
+	addReadinessFlags(upgradeCmd, &upgradeOpts, &upgradeHealth)
+	_ = upgradeCmd.MarkFlagRequired("plugin-id")
+	_ = upgradeCmd.MarkFlagRequired("executable")
+	_ = upgradeCmd.MarkFlagRequired("plugin-version")
+}
+
+func runUpgrade(cmd *cobra.Command, _ []string) error {
+	exe, err := absPath(upgradeOpts.Executable)
+	if err != nil {
+		return err
+	}
+	upgradeOpts.Executable = exe
+	sig, err := loadSignature(upgradeSig, upgradeOpts.Executable)
+	if err != nil {
+		return err
+	}
+	upgradeOpts.Signature = sig
+	upgradeOpts.HealthCmd = healthArgv(upgradeHealth)
+	upgradeOpts.NoPreflight = !upgradePreflight
+	upgradeOpts.Launch = shimLauncher(cmd)
+	return runtime.New(mustRoot(cmd)).Upgrade(context.Background(), upgradePluginID, upgradeOpts)
+}
+
+func init() { rootCmd.AddCommand(upgradeCmd) }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tomatopunk/agent-runtime/internal/runtime"
+)
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manage named volumes (run --mount type=volume)",
+}
+
+var volumeListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List named volumes and the plugins using them",
+	RunE:    runVolumeList,
+}
+
+var volumeRemoveCmd = &cobra.Command{
+	Use:     "rm NAME...",
+	Aliases: []string{"remove"},
+	Short:   "Remove named volumes and their data (refused while a plugin uses them)",
+	Args:    cobra.MinimumNArgs(1),
+	RunE:    runVolumeRemove,
+}
+
+var volumeFormat string
+
+func init() {
+	volumeListCmd.Flags().StringVar(&volumeFormat, "format", "text", "output format: text | json")
+}
+
+func runVolumeList(cmd *cobra.Command, _ []string) error {
+	rt := runtime.New(mustRoot(cmd))
+	vols, err := rt.Volumes()
+	if err != nil {
+		return err
+	}
+	if volumeFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vols)
+	}
+	for _, v := range vols {
+		fmt.Printf("%s\t%s\t%s\n", v.Name, v.Path, strings.Join(v.UsedBy, ","))
+	}
+	return nil
+}
+
+func runVolumeRemove(cmd *cobra.Command, args []string) error {
+	rt := runtime.New(mustRoot(cmd))
+	var errs []error
+	for _, name := range args {
+		if err := rt.RemoveVolume(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	volumeCmd.AddCommand(volumeListCmd, volumeRemoveCmd)
+	rootCmd.AddCommand(volumeCmd)
+}
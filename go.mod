@@ -4,11 +4,11 @@ go 1.24.1
 
 require (
 	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
 	go.uber.org/zap v1.27.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 )
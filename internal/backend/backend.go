@@ -2,8 +2,13 @@ package backend
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/tomatopunk/agent-runtime/internal/resources"
 )
 
 // Backend is the runtime backend interface implemented by binary and runc.
@@ -27,30 +32,175 @@ type Backend interface {
 
 // RunOptions are the options for starting a plugin.
 type RunOptions struct {
-	PluginID      string   // injected as PLUGIN_ID env (binary + runc)
-	PluginVersion string   // injected as PLUGIN_VERSION env
-	DeviceId      string   // injected as DEVICE_ID env
-	HostType      string   // injected as HOST_TYPE env
-	HostName      string   // injected as HOST_NAME env
-	RootDir       string   // runtime root dir
-	WorkDir       string   // for binary: work dir (cwd); for runc: bundle path
+	PluginID      string // injected as PLUGIN_ID env (binary + runc)
+	PluginVersion string // injected as PLUGIN_VERSION env (binary + runc)
+	DeviceId      string // injected as DEVICE_ID env (binary + runc)
+	HostType      string // injected as HOST_TYPE env (binary + runc)
+	HostName      string // injected as HOST_NAME env (binary + runc)
+	// StartedAt is when this instance was started, filled in by the runtime and injected as
+	// PLUGIN_INSTANCE_START; see PluginEnv for the whole injected environment.
+	StartedAt time.Time
+	// RuntimeSocket is the host path of the agent's runtime API socket (config.json runtime_socket),
+	// filled in by the runtime; injected as PLUGIN_RUNTIME_SOCKET and, for runc, mounted read-only.
+	RuntimeSocket string
+	RootDir       string // runtime root dir
+	WorkDir       string // for binary: work dir (cwd); for runc: bundle path
 	// Executable: host path to the binary to run. Binary backend runs it directly;
 	// runc backend copies it into bundle rootfs and runs it inside the container.
-	Executable string   // required
-	Args       []string // optional args: binary = append to launch command; runc = args inside container
-	CPU        string   // cgroup CPU quota, e.g. "0.5"
-	Mem        string   // cgroup memory quota, e.g. "128m"
-	Env        []string // extra KEY=VALUE env (in addition to injected vars)
+	Executable string // required, except for runc with Image (then an optional entrypoint path inside the image)
+	// Image is an OCI image layout reference, oci-layout:<dir>[:<tag>] (runc only). Its rootfs replaces the
+	// single-binary rootfs and its Entrypoint/Cmd/Env/WorkingDir are the process defaults.
+	Image string
+	// BaseRootfs is a host dir overlaid read-only under the runc rootfs (below the image, if any), e.g. an
+	// unpacked busybox providing a shell and tools. Unset falls back to the runtime config.
+	BaseRootfs string
+	// SpecOverride is the runtime-wide override file for the generated runc config.json (config.json
+	// runc.spec_override); it is filled in by the runtime and applied before SpecPatches.
+	SpecOverride string
+	// AllowedHostPaths are the host dirs runc bind mounts may come from (config.json
+	// runc.allowed_host_paths); filled in by the runtime, they also hold for binds in the final spec.
+	AllowedHostPaths []string
+	// SpecPatches are the plugin's override files for the generated runc config.json, applied in order: a
+	// JSON object is a merge patch (RFC 7386), a JSON array a JSON patch (RFC 6902).
+	SpecPatches []string
+	// SpecFile is a complete runc config.json used verbatim instead of the generated one: it gets no
+	// data, log or runtime socket mount and no PLUGIN_* env, and excludes SpecPatches, Devices, Mounts,
+	// HostDir and Secrets.
+	SpecFile string
+	// SecurityProfile is the runc spec's security baseline: default | restricted | privileged. Spec
+	// overrides apply on top of it.
+	SecurityProfile string
+	// Devices are host device nodes passed through to a runc plugin, HOST[:CONTAINER][:PERMS]; HOST may
+	// be a glob such as /dev/ttyUSB*. Other devices are denied.
+	Devices []string
+	// Mounts are extra bind mounts and named volumes of a runc plugin; bind sources must be allowed by
+	// the runtime config (runc.allowed_host_paths).
+	Mounts []Mount
+	// HostDir bind-mounts the host / read-only at /host in a runc plugin and sets HOST_DIR=/host.
+	HostDir bool
+	// Isolation sandboxes a binary-backend plugin without runc; the zero value runs it like the agent.
+	Isolation Isolation
+	Args      []string // optional args: binary = append to launch command; runc = args inside container
+	Env       []string // extra KEY=VALUE env (in addition to injected vars); persisted, so not for secrets
+	// EnvFiles are env files (see ReadEnvFile) read at each start; Env overrides them.
+	EnvFiles []string
+	// EnvPolicy is how much of the agent's environment the plugin inherits: inherit | clean | allowlist
+	// (with EnvAllow naming the variables). The runtime defaults it to inherit for binary, clean for runc.
+	EnvPolicy string
+	EnvAllow  []string
+	// Secrets are read from their host files at each start and delivered as files (in the dir named by
+	// PLUGIN_SECRETS_DIR) or env vars; only the references are persisted.
+	Secrets []Secret
+	// Digest pins the executable ("sha256:<hex>"); once verified by the runtime it is the verified
+	// digest for a pinned or signed run and empty otherwise.
+	Digest string
+	// Signature is the base64 ed25519 signature over Digest, checked against the trusted keys dir.
+	Signature string
+	// Labels identify the plugin for selectors (list/stop/delete/destroy -l); runc also gets them as annotations.
+	Labels map[string]string
+	// Resources are the cgroup limits; binary applies them via a cgroup v2 dir, runc via linux.resources.
+	Resources resources.Options
+	// LogRotation limits the on-disk plugin log; unset fields fall back to the runtime config, then defaults.
+	LogRotation LogRotation
+	// LogLimits rate-limit the plugin's output and cap line length; unset fields fall back to the runtime config.
+	LogLimits LogLimits
+	// LogParser extracts level/message/fields when reading the log: auto | json | logfmt | none | regex:<expr>.
+	LogParser string
+	// LogDrivers are the log drivers the shim fans plugin output out to: file | syslog | journald | ring (default file).
+	LogDrivers []string
+	// LogOpts are driver options, e.g. syslog-address=udp://host:514, journald-socket=..., ring-size=1000.
+	LogOpts map[string]string
+}
+
+// Isolation is the binary backend's lightweight sandbox, applied through the plugin's SysProcAttr
+// and the thread that starts it.
+type Isolation struct {
+	User       string   `json:"user,omitempty"`       // uid[:gid] or name[:group] to run as
+	Groups     []string `json:"groups,omitempty"`     // supplementary groups (gids or names); dropped if User is set without them
+	Namespaces []string `json:"namespaces,omitempty"` // new namespaces: mount | pid | ipc | uts | net
+	// Root is a prepared dir the plugin is chrooted into; Executable (and WorkDir, else /) must lie inside it.
+	Root            string `json:"root,omitempty"`
+	NoNewPrivileges bool   `json:"no_new_privileges,omitempty"`
+	DropCaps        bool   `json:"drop_caps,omitempty"`     // empty capability bounding, ambient and inheritable sets
+	DieWithShim     bool   `json:"die_with_shim,omitempty"` // SIGKILL the plugin if its shim dies (Pdeathsig)
+	// Landlock confines the plugin's filesystem access with the Landlock LSM; nil leaves it unrestricted.
+	Landlock *LandlockRules `json:"landlock,omitempty"`
+}
+
+// LandlockRules are a plugin's Landlock filesystem rules. Besides these, the plugin may read and
+// execute its executable and the system dirs (/usr, /lib, /etc, ...) and write its WorkDir. On a
+// kernel without Landlock the plugin runs unrestricted; state reports the ABI that was applied.
+type LandlockRules struct {
+	ReadOnly  []string `json:"read_only,omitempty"`
+	ReadWrite []string `json:"read_write,omitempty"` // data dirs
+}
+
+// IsolationNamespaces are the namespace names Isolation.Namespaces accepts.
+var IsolationNamespaces = []string{"mount", "pid", "ipc", "uts", "net"}
+
+// IsZero reports whether no isolation is requested.
+func (i Isolation) IsZero() bool {
+	return i.User == "" && len(i.Groups) == 0 && len(i.Namespaces) == 0 && i.Root == "" &&
+		!i.NoNewPrivileges && !i.DropCaps && !i.DieWithShim && i.Landlock == nil
+}
+
+// Validate checks the namespace names and that Root is absolute.
+func (i Isolation) Validate() error {
+	for _, ns := range i.Namespaces {
+		known := false
+		for _, n := range IsolationNamespaces {
+			known = known || ns == n
+		}
+		if !known {
+			return fmt.Errorf("unknown namespace %q: want one of %s", ns, strings.Join(IsolationNamespaces, ", "))
+		}
+	}
+	if i.Root != "" && !filepath.IsAbs(i.Root) {
+		return fmt.Errorf("isolation root %q must be an absolute path", i.Root)
+	}
+	if i.Landlock != nil {
+		for _, p := range append(append([]string(nil), i.Landlock.ReadOnly...), i.Landlock.ReadWrite...) {
+			if !filepath.IsAbs(p) {
+				return fmt.Errorf("landlock path %q must be absolute", p)
+			}
+		}
+	}
+	return nil
+}
+
+// LogRotation configures size-based rotation of the plugin log written by the shim.
+type LogRotation struct {
+	MaxSize  string `json:"max_size,omitempty"`  // rotate once the live file reaches this size, e.g. "10Mi"
+	MaxFiles *int   `json:"max_files,omitempty"` // rotated segments to keep; 0 keeps none
+	Compress *bool  `json:"compress,omitempty"`  // gzip rotated segments
+}
+
+// Merge returns r with unset fields taken from d.
+func (r LogRotation) Merge(d LogRotation) LogRotation {
+	if r.MaxSize == "" {
+		r.MaxSize = d.MaxSize
+	}
+	if r.MaxFiles == nil {
+		r.MaxFiles = d.MaxFiles
+	}
+	if r.Compress == nil {
+		r.Compress = d.Compress
+	}
+	return r
 }
 
 // InstanceInfo is a plugin summary for list output.
 type InstanceInfo struct {
-	PluginID  string    `json:"plugin_id"`
-	Backend   string    `json:"backend"` // "binary" | "runc"
-	Status    string    `json:"status"`  // "running" | "stopped" | "unknown"
-	Pid       int       `json:"pid,omitempty"`
-	StartedAt time.Time `json:"started_at,omitempty"`
-	WorkDir   string    `json:"work_dir,omitempty"`
+	PluginID      string            `json:"plugin_id"`
+	Backend       string            `json:"backend"` // "binary" | "runc"
+	Status        string            `json:"status"`  // "running" | "stopped" | "unknown"
+	Pid           int               `json:"pid,omitempty"`
+	StartedAt     time.Time         `json:"started_at,omitempty"`
+	WorkDir       string            `json:"work_dir,omitempty"`
+	PluginVersion string            `json:"plugin_version,omitempty"`
+	DeviceID      string            `json:"device_id,omitempty"`
+	HostType      string            `json:"host_type,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
 }
 
 // StateInfo is the state of a single plugin for state output.
@@ -62,6 +212,66 @@ type StateInfo struct {
 	StartedAt  time.Time `json:"started_at,omitempty"`
 	ExitStatus int       `json:"exit_status,omitempty"`
 	WorkDir    string    `json:"work_dir,omitempty"`
+	// LogDroppedLines counts output lines dropped by the log rate limit since the plugin started.
+	LogDroppedLines int64  `json:"log_dropped_lines,omitempty"`
+	PluginVersion   string `json:"plugin_version,omitempty"`
+	Digest          string `json:"digest,omitempty"`
+	SignedBy        string `json:"signed_by,omitempty"` // trusted key ID
+	// Landlock is "enforced" or "unsupported" (kernel without Landlock) for plugins with Landlock rules.
+	Landlock    string `json:"landlock,omitempty"`
+	LandlockABI int    `json:"landlock_abi,omitempty"`
+	// History lists the versions this plugin has run, oldest first (see upgrade/rollback).
+	History []VersionRecord `json:"history,omitempty"`
+}
+
+// Version statuses recorded in the history.
+const (
+	VersionActive     = "active"      // currently running
+	VersionPending    = "pending"     // upgrade in progress, not ready yet
+	VersionSuperseded = "superseded"  // replaced by a later version; kept for rollback
+	VersionFailed     = "failed"      // did not become ready; the previous version was restored
+	VersionRolledBack = "rolled_back" // replaced by a manual rollback
+)
+
+// VersionRecord is one entry of a plugin's version history.
+type VersionRecord struct {
+	Version    string    `json:"version"`
+	Executable string    `json:"executable"` // executable the version was started from
+	Digest     string    `json:"digest,omitempty"`
+	Signature  string    `json:"signature,omitempty"`
+	Snapshot   string    `json:"snapshot,omitempty"` // dir holding the kept executable (and runc rootfs) for rollback
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"` // why the version failed
+}
+
+// LogLimits cap how much a plugin may log; zero values mean unlimited.
+type LogLimits struct {
+	LinesPerSec float64 `json:"lines_per_sec,omitempty"`
+	LineBurst   int     `json:"line_burst,omitempty"` // lines allowed above the rate at once (default: one second's worth, at least 1)
+	BytesPerSec string  `json:"bytes_per_sec,omitempty"`
+	ByteBurst   string  `json:"byte_burst,omitempty"`    // default: one second's worth, at least one line (max_line_size or 16Ki)
+	MaxLineSize string  `json:"max_line_size,omitempty"` // longer lines are truncated with a marker
+}
+
+// Merge returns l with unset fields taken from d.
+func (l LogLimits) Merge(d LogLimits) LogLimits {
+	if l.LinesPerSec == 0 {
+		l.LinesPerSec = d.LinesPerSec
+	}
+	if l.LineBurst == 0 {
+		l.LineBurst = d.LineBurst
+	}
+	if l.BytesPerSec == "" {
+		l.BytesPerSec = d.BytesPerSec
+	}
+	if l.ByteBurst == "" {
+		l.ByteBurst = d.ByteBurst
+	}
+	if l.MaxLineSize == "" {
+		l.MaxLineSize = d.MaxLineSize
+	}
+	return l
 }
 
 // LogOptions are options for reading logs.
@@ -70,9 +280,31 @@ type LogOptions struct {
 	End    *time.Time
 	Length int    // max number of lines, 0 = no limit
 	Format string // "json" | "text"
+	// Follow keeps the reader open and streams new lines until ctx is cancelled.
+	Follow bool
+	// UntilExit ends a Follow stream once the plugin has exited and its output is drained.
+	UntilExit bool
+	// Level keeps only lines whose parsed level is at least this (trace|debug|info|warn|error|fatal).
+	Level string
+	// Grep keeps only lines whose message matches this regular expression.
+	Grep string
+	// Fields keeps only lines whose parsed fields equal these values.
+	Fields map[string]string
 }
 
 const (
 	BackendBinary = "binary"
 	BackendRunc   = "runc"
 )
+
+// Security profiles for runc plugins (RunOptions.SecurityProfile).
+const (
+	// SecurityDefault is the built-in spec: root (or the image's User) with NET_RAW/NET_ADMIN and no
+	// seccomp filtering.
+	SecurityDefault = "default"
+	// SecurityRestricted runs as nobody (keeping a non-root image User) with no capabilities and no_new_privileges, a default-deny
+	// seccomp allowlist, masked /proc paths, no HostDir and a read-only rootfs with a tmpfs /tmp.
+	SecurityRestricted = "restricted"
+	// SecurityPrivileged runs as root (or the image's User) with every capability, access to every device and no seccomp filter.
+	SecurityPrivileged = "privileged"
+)
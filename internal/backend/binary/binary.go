@@ -2,29 +2,51 @@ package binary
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	goruntime "runtime"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/logger"
+	"github.com/tomatopunk/agent-runtime/internal/logs"
+	"github.com/tomatopunk/agent-runtime/internal/resources"
 	"github.com/tomatopunk/agent-runtime/internal/state"
+	"go.uber.org/zap"
 )
 
 // Backend runs processes on the host; optional cgroup, log to file.
 type Backend struct {
 	state *state.Manager
+	log   *zap.Logger
 	mu    sync.Mutex
-	// pluginID -> cmd for processes started by this process (used by Stop to signal)
-	running map[string]*exec.Cmd
+	// pluginID -> process started by this process (used by Stop to signal)
+	running map[string]*process
 }
 
-func New(stateManager *state.Manager) *Backend {
-	return &Backend{state: stateManager, running: make(map[string]*exec.Cmd)}
+// process is a plugin started by this runtime process. A single goroutine reaps it,
+// so Wait and Stop can both block on done without calling cmd.Wait twice.
+type process struct {
+	cmd  *exec.Cmd
+	done chan struct{} // closed once the process has exited and its output is flushed
+}
+
+func New(stateManager *state.Manager, log *zap.Logger) *Backend {
+	if log == nil {
+		log = logger.NewNop()
+	}
+	return &Backend{state: stateManager, log: log, running: make(map[string]*process)}
+}
+
+// pluginLog returns the backend logger with the plugin's fields.
+func (b *Backend) pluginLog(pluginID string) *zap.Logger {
+	return logger.ForPlugin(b.log, pluginID, backend.BackendBinary)
 }
 
 func (b *Backend) Run(ctx context.Context, opts backend.RunOptions) error {
@@ -33,59 +55,202 @@ func (b *Backend) Run(ctx context.Context, opts backend.RunOptions) error {
 	if opts.PluginID == "" || opts.WorkDir == "" || opts.Executable == "" {
 		return fmt.Errorf("plugin_id, work_dir and executable are required")
 	}
+	spec, err := opts.Resources.Parse()
+	if err != nil {
+		return err
+	}
+	attr, err := sysProcAttr(opts.Isolation)
+	if err != nil {
+		return err
+	}
+	// A pinned or signed run starts a private copy of the verified executable, never the path the
+	// runtime hashed, which could be replaced in between; argv[0] stays the configured path. An
+	// unpinned run starts the path itself.
+	exe := opts.Executable
+	if opts.Digest != "" {
+		if exe, err = privateExecutable(b.execDir(opts.PluginID, opts.Isolation.Root), opts.Executable, opts.Digest); err != nil {
+			return err
+		}
+	}
 	// Build launch command: executable path + optional args
-	cmd := exec.CommandContext(ctx, opts.Executable, opts.Args...)
+	cmd := exec.CommandContext(ctx, exe, opts.Args...)
+	cmd.Args[0] = opts.Executable
 	cmd.Dir = opts.WorkDir
-	// Inherit host env, inject runtime env (binary has no fs isolation so HOST_DIR=/), then add opts.Env
-	cmd.Env = make([]string, 0, len(os.Environ())+6+len(opts.Env))
-	cmd.Env = append(cmd.Env, os.Environ()...)
-	cmd.Env = append(cmd.Env,
-		"PLUGIN_ID="+opts.PluginID,
-		"PLUGIN_VERSION="+opts.PluginVersion,
-		"DEVICE_ID="+opts.DeviceId,
-		"HOST_TYPE="+opts.HostType,
-		"HOST_NAME="+opts.HostName,
-		"HOST_DIR=/", // binary does not isolate fs
-	)
-	cmd.Env = append(cmd.Env, opts.Env...)
-	logPath := b.logPath(opts.PluginID)
-	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
-		return err
+	if opts.Isolation.Root != "" {
+		// Both are resolved after the chroot.
+		if cmd.Path, cmd.Dir, err = chrootPaths(opts.Isolation.Root, exe, opts.WorkDir); err != nil {
+			return err
+		}
 	}
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	cmd.SysProcAttr = attr
+	if backend.HasSecretFiles(opts.Secrets) && opts.Isolation.Root != "" {
+		return fmt.Errorf("file secrets are not reachable from a chroot; use env secrets")
+	}
+	// The shim owns the output pipes and runs them through the log pipeline; the plugin never sees the file.
+	out, err := logs.NewPipeline(logs.DriverConfig{
+		Names:    opts.LogDrivers,
+		Options:  opts.LogOpts,
+		PluginID: opts.PluginID,
+		DeviceID: opts.DeviceId,
+		FilePath: b.state.LogPath(opts.PluginID),
+		Socket:   b.state.LogSocket(opts.PluginID),
+		Rotation: opts.LogRotation,
+		Limits:   opts.LogLimits,
+		OnDropped: func(total int64) {
+			if err := b.state.WriteLogDropped(opts.PluginID, total); err != nil {
+				b.pluginLog(opts.PluginID).Warn("record dropped log lines failed", zap.Error(err))
+			}
+		},
+		OnError: func(err error) {
+			b.pluginLog(opts.PluginID).Warn("plugin log driver failed", zap.Error(err))
+		},
+	})
 	if err != nil {
 		return err
 	}
-	defer logFile.Close()
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	if err := cmd.Start(); err != nil {
+	cmd.Stdout = out.Stdout()
+	cmd.Stderr = out.Stderr()
+	if !spec.IsZero() {
+		// Start the process directly inside its cgroup so limits apply from the first instruction.
+		cg, err := resources.CreateCgroup(opts.PluginID, spec)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		defer cg.Close()
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(cg.Fd())
+	}
+	log := b.pluginLog(opts.PluginID)
+	dataDir, logDir := b.state.DataDir(opts.PluginID), b.state.PluginLogDir(opts.PluginID)
+	uid, gid := pluginOwner(attr.Credential)
+	for _, dir := range []string{dataDir, logDir} {
+		if err := backend.MakePluginDir(dir, uid, gid); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	var secrets string
+	if backend.HasSecretFiles(opts.Secrets) {
+		if secrets, err = writeSecrets(opts.PluginID, opts.Secrets, attr.Credential); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	// cleanup runs once the plugin has exited or failed to start, on another goroutine (and so
+	// another thread): the start thread may be confined by Landlock. It removes the secrets dir and,
+	// after an exit, the pid file, whose pid may be reused by an unrelated process.
+	cleanup := func(exited bool) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if secrets != "" {
+				if err := os.RemoveAll(secrets); err != nil {
+					log.Warn("remove secrets dir failed", zap.Error(err))
+				}
+			}
+			if exited {
+				if err := b.state.RemovePid(opts.PluginID); err != nil {
+					log.Warn("remove pid file failed", zap.Error(err))
+				}
+			}
+		}()
+		<-done
+	}
+	if cmd.Env, err = b.pluginEnv(opts, secrets); err != nil {
+		cleanup(false)
+		out.Close()
+		return err
+	}
+	proc := &process{cmd: cmd, done: make(chan struct{})}
+	started := make(chan error, 1)
+	var abi int
+	go func() {
+		// Start and reap on one OS thread that is never unlocked (it exits with this goroutine): the
+		// thread-scoped isolation reaches only the plugin, and Pdeathsig, which fires when the parent
+		// thread exits, then means the shim went away rather than that Go retired a thread.
+		goruntime.LockOSThread()
+		var err error
+		if abi, err = restrictThread(opts.Isolation, exe, secrets, []string{opts.WorkDir, dataDir, logDir}); err != nil {
+			cleanup(false)
+			started <- err
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			cleanup(false)
+			started <- err
+			return
+		}
+		started <- nil
+		err = cmd.Wait()
+		cleanup(true)
+		if err := out.Close(); err != nil {
+			log.Warn("close log pipeline failed", zap.Error(err))
+		}
+		log.Info("plugin process exited", zap.Int("pid", cmd.Process.Pid), zap.NamedError("exit", err))
+		close(proc.done)
+	}()
+	if err := <-started; err != nil {
+		out.Close()
 		return err
 	}
 	pid := cmd.Process.Pid
+	log.Debug("plugin process started", zap.Int("pid", pid))
+	if err := applyProcessSettings(pid, spec); err != nil {
+		if kerr := cmd.Process.Kill(); kerr != nil {
+			log.Error("kill plugin after process settings failure failed", zap.Error(kerr))
+		}
+		return err
+	}
+	if opts.Isolation.Landlock != nil {
+		// Written here, not on the start thread, which Landlock now confines too.
+		if abi == 0 {
+			log.Warn("kernel has no Landlock support, plugin filesystem access is not restricted")
+		}
+		if err := b.state.WriteLandlockABI(opts.PluginID, abi); err != nil {
+			if kerr := cmd.Process.Kill(); kerr != nil {
+				log.Error("kill plugin after state write failure failed", zap.Error(kerr))
+			}
+			return err
+		}
+	}
 	if err := b.state.WritePid(opts.PluginID, pid); err != nil {
-		_ = cmd.Process.Kill()
+		if kerr := cmd.Process.Kill(); kerr != nil {
+			log.Error("kill plugin after pid write failure failed", zap.Error(kerr))
+		}
 		return err
 	}
-	b.running[opts.PluginID] = cmd
+	b.running[opts.PluginID] = proc
 	return nil
 }
 
+// pluginEnv returns the environment the plugin of opts is started with, its file secrets in secretsDir.
+// The binary backend does not isolate the filesystem, so HOST_DIR is / and the rest are host paths
+// (as seen from the chroot, with one).
+func (b *Backend) pluginEnv(opts backend.RunOptions, secretsDir string) ([]string, error) {
+	paths := backend.EnvPaths{
+		HostDir:       "/",
+		SecretsDir:    secretsDir,
+		DataDir:       b.state.DataDir(opts.PluginID),
+		LogDir:        b.state.PluginLogDir(opts.PluginID),
+		RuntimeSocket: opts.RuntimeSocket,
+	}
+	if opts.Isolation.Root != "" {
+		paths = chrootEnvPaths(opts.Isolation.Root, paths)
+	}
+	return backend.PluginEnv(opts, nil, paths)
+}
+
 // Wait blocks until the plugin process exits or ctx is cancelled (used by re-exec'd shim).
 func (b *Backend) Wait(ctx context.Context, pluginID string) error {
 	b.mu.Lock()
-	cmd, ok := b.running[pluginID]
+	proc, ok := b.running[pluginID]
 	b.mu.Unlock()
-	if !ok || cmd == nil {
+	if !ok || proc == nil {
 		return nil
 	}
-	done := make(chan struct{})
-	go func() {
-		_ = cmd.Wait()
-		close(done)
-	}()
 	select {
-	case <-done:
+	case <-proc.done:
 		b.mu.Lock()
 		delete(b.running, pluginID)
 		b.mu.Unlock()
@@ -95,51 +260,66 @@ func (b *Backend) Wait(ctx context.Context, pluginID string) error {
 	}
 }
 
-func (b *Backend) logPath(pluginID string) string {
-	return filepath.Join(b.state.StateDir(), "..", "logs", pluginID, "stdout.log")
-}
-
 func (b *Backend) Stop(ctx context.Context, pluginID string) error {
 	b.mu.Lock()
-	cmd, ok := b.running[pluginID]
+	proc, ok := b.running[pluginID]
 	b.mu.Unlock()
-	if ok && cmd.Process != nil {
-		_ = cmd.Process.Signal(syscall.SIGTERM)
-		done := make(chan struct{})
-		go func() {
-			_ = cmd.Wait()
-			close(done)
-		}()
+	log := b.pluginLog(pluginID)
+	if ok && proc.cmd.Process != nil {
+		if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			log.Warn("send SIGTERM failed", zap.Error(err))
+		}
 		select {
-		case <-done:
+		case <-proc.done:
 		case <-time.After(10 * time.Second):
-			_ = cmd.Process.Kill()
+			log.Warn("plugin did not exit after SIGTERM, killing")
+			if err := proc.cmd.Process.Kill(); err != nil {
+				log.Error("kill plugin failed", zap.Error(err))
+			}
+			<-proc.done
 		}
 		b.mu.Lock()
 		delete(b.running, pluginID)
 		b.mu.Unlock()
 		return nil
 	}
-	// Maybe managed by another runtime process; kill via pid file
-	pid, err := b.state.ReadPid(pluginID)
-	if err != nil || pid <= 0 {
+	// Maybe managed by another runtime process; kill via pid file, unless the pid now belongs to
+	// another process.
+	pid, start, err := b.state.ReadPidStart(pluginID)
+	if err != nil || !state.SameProcess(pid, start) {
 		return nil
 	}
-	proc, err := os.FindProcess(pid)
+	other, err := os.FindProcess(pid)
 	if err != nil {
 		return nil
 	}
-	_ = proc.Signal(syscall.SIGTERM)
+	if err := other.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Warn("send SIGTERM failed", zap.Int("pid", pid), zap.Error(err))
+	}
 	return nil
 }
 
 func (b *Backend) Delete(ctx context.Context, pluginID string) error {
+	log := b.pluginLog(pluginID)
 	if err := b.Stop(ctx, pluginID); err != nil {
-		// ignore
+		log.Warn("stop before delete failed", zap.Error(err))
 	}
 	meta, err := b.state.LoadMeta(pluginID)
 	if err == nil && meta.WorkDir != "" {
-		_ = os.RemoveAll(meta.WorkDir)
+		if err := os.RemoveAll(meta.WorkDir); err != nil {
+			log.Warn("remove work dir failed", zap.String("work_dir", meta.WorkDir), zap.Error(err))
+		}
+	}
+	if err == nil && meta.Isolation.Root != "" {
+		// The copy under the runtime root goes with the rest of the state below.
+		dir := b.execDir(pluginID, meta.Isolation.Root)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn("remove executable copy failed", zap.String("dir", dir), zap.Error(err))
+		}
+		_ = os.Remove(filepath.Dir(dir)) // only if no other plugin's copy is left
+	}
+	if err := resources.RemoveCgroup(pluginID); err != nil {
+		log.Warn("remove cgroup failed", zap.Error(err))
 	}
 	return b.state.Remove(pluginID)
 }
@@ -160,20 +340,19 @@ func (b *Backend) List(ctx context.Context) ([]backend.InstanceInfo, error) {
 		}
 		pid, _ := b.state.ReadPid(id)
 		status := "stopped"
-		if pid > 0 {
-			if proc, _ := os.FindProcess(pid); proc != nil {
-				// Simple check: signal 0 to see if process is alive
-				if err := proc.Signal(syscall.Signal(0)); err == nil {
-					status = "running"
-				}
-			}
+		if pidAlive(pid) {
+			status = "running"
 		}
 		info := backend.InstanceInfo{
-			PluginID: id,
-			Backend:  backend.BackendBinary,
-			Status:   status,
-			Pid:      pid,
-			WorkDir:  meta.WorkDir,
+			PluginID:      id,
+			Backend:       backend.BackendBinary,
+			Status:        status,
+			Pid:           pid,
+			WorkDir:       meta.WorkDir,
+			PluginVersion: meta.PluginVersion,
+			DeviceID:      meta.DeviceId,
+			HostType:      meta.HostType,
+			Labels:        meta.Labels,
 		}
 		out = append(out, info)
 	}
@@ -190,12 +369,8 @@ func (b *Backend) State(ctx context.Context, pluginID string) (*backend.StateInf
 	}
 	pid, _ := b.state.ReadPid(pluginID)
 	status := "stopped"
-	if pid > 0 {
-		if proc, _ := os.FindProcess(pid); proc != nil {
-			if err := proc.Signal(syscall.Signal(0)); err == nil {
-				status = "running"
-			}
-		}
+	if pidAlive(pid) {
+		status = "running"
 	}
 	return &backend.StateInfo{
 		PluginID: pluginID,
@@ -207,16 +382,39 @@ func (b *Backend) State(ctx context.Context, pluginID string) (*backend.StateInf
 }
 
 func (b *Backend) Log(ctx context.Context, pluginID string, opts backend.LogOptions) (io.Reader, error) {
-	p := b.logPath(pluginID)
-	f, err := os.Open(p)
+	meta, err := b.state.LoadMeta(pluginID)
 	if err != nil {
 		return nil, err
 	}
-	if opts.Length <= 0 {
-		return f, nil
+	exited := func() bool {
+		pid, _ := b.state.ReadPid(pluginID)
+		return !pidAlive(pid)
+	}
+	src := logs.Source{
+		Drivers:  meta.LogDrivers,
+		FilePath: b.state.LogPath(pluginID),
+		Socket:   b.state.LogSocket(pluginID),
+		Parser:   meta.LogParser,
+		Meta: logs.Metadata{
+			PluginID:      pluginID,
+			PluginVersion: meta.PluginVersion,
+			DeviceID:      meta.DeviceId,
+			Backend:       backend.BackendBinary,
+		},
+	}
+	return logs.Read(ctx, src, opts, exited)
+}
+
+// pidAlive reports whether pid refers to a live process (signal 0).
+func pidAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
 	}
-	// Simple impl: read last N lines (can be improved with ring buffer later)
-	return f, nil
+	return proc.Signal(syscall.Signal(0)) == nil
 }
 
 // IsRunning returns whether this process is managing the plugin.
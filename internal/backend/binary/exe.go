@@ -0,0 +1,81 @@
+package binary
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/tomatopunk/agent-runtime/internal/verify"
+)
+
+// chrootExecDir is where, inside an isolation root, the private executable copies live: the plugin
+// cannot reach a copy outside its chroot.
+const chrootExecDir = ".agent-runtime"
+
+// execDir returns the dir for the plugin's private executable copy: under the runtime root, or
+// inside the isolation root when there is one.
+func (b *Backend) execDir(pluginID, root string) string {
+	if root != "" {
+		return filepath.Join(root, chrootExecDir, pluginID)
+	}
+	return b.state.ExecDir(pluginID)
+}
+
+// privateExecutable copies executable into dir and returns the copy's path. The copy is hashed as it
+// is written and must match digest (the one the runtime verified), so the plugin runs exactly the
+// verified bytes even if the file at executable is replaced afterwards: only the runtime's user can
+// write dir and the copy. A later start replaces the copy; a running plugin keeps its own.
+func privateExecutable(dir, executable, digest string) (string, error) {
+	if err := makeExecDir(dir); err != nil {
+		return "", err
+	}
+	src, err := os.Open(executable)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(dir, ".copy-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	got, err := verify.DigestOf(io.TeeReader(src, tmp))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("copy executable %s: %w", executable, err)
+	}
+	if got != digest {
+		return "", fmt.Errorf("executable %s changed after verification (digest %s, verified %s)", executable, got, digest)
+	}
+	if err := os.Chmod(tmp.Name(), 0555); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, filepath.Base(executable))
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// makeExecDir creates dir and its parent traversable by the plugin's user but writable only by the
+// runtime's, and refuses ones that already exist with looser ownership or modes.
+func makeExecDir(dir string) error {
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Mkdir(d, 0711); err != nil && !os.IsExist(err) {
+			return err
+		}
+		fi, err := os.Lstat(d)
+		if err != nil {
+			return err
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm()&0022 != 0 {
+			return fmt.Errorf("executable dir %s must be a dir owned by uid %d and not group or world writable", d, os.Getuid())
+		}
+	}
+	return nil
+}
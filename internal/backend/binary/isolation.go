@@ -0,0 +1,226 @@
+package binary
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// Not in package syscall.
+const (
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	linuxCapabilityV3    = 0x20080522
+)
+
+var namespaceFlags = map[string]uintptr{
+	"mount": syscall.CLONE_NEWNS,
+	"pid":   syscall.CLONE_NEWPID,
+	"ipc":   syscall.CLONE_NEWIPC,
+	"uts":   syscall.CLONE_NEWUTS,
+	"net":   syscall.CLONE_NEWNET,
+}
+
+// sysProcAttr builds the plugin's SysProcAttr from its isolation: credentials, namespaces, chroot
+// and Pdeathsig. The caller adds the cgroup fd.
+func sysProcAttr(iso backend.Isolation) (*syscall.SysProcAttr, error) {
+	attr := &syscall.SysProcAttr{}
+	if iso.User != "" || len(iso.Groups) > 0 {
+		cred, err := credential(iso.User, iso.Groups)
+		if err != nil {
+			return nil, err
+		}
+		attr.Credential = cred
+	}
+	for _, ns := range iso.Namespaces {
+		flag, ok := namespaceFlags[ns]
+		if !ok {
+			return nil, fmt.Errorf("unknown namespace %q", ns)
+		}
+		attr.Cloneflags |= flag
+	}
+	attr.Chroot = iso.Root
+	if iso.DieWithShim {
+		attr.Pdeathsig = syscall.SIGKILL
+	}
+	return attr, nil
+}
+
+// credential resolves user ("uid[:gid]" or "name[:group]") and the supplementary groups. Without
+// a gid the user's primary group is used; the supplementary groups are exactly groups.
+func credential(spec string, groups []string) (*syscall.Credential, error) {
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if spec != "" {
+		name, group, hasGroup := strings.Cut(spec, ":")
+		uid, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			u, lerr := user.Lookup(name)
+			if lerr != nil {
+				return nil, fmt.Errorf("isolation user %q: %w", name, lerr)
+			}
+			uid, _ = strconv.ParseUint(u.Uid, 10, 32)
+			if !hasGroup {
+				gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+				cred.Gid = uint32(gid)
+			}
+		} else if !hasGroup {
+			// A bare uid runs with the same number as gid, like runc's --user.
+			cred.Gid = uint32(uid)
+		}
+		cred.Uid = uint32(uid)
+		if hasGroup {
+			gid, err := lookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			cred.Gid = gid
+		}
+	}
+	for _, g := range groups {
+		gid, err := lookupGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, gid)
+	}
+	return cred, nil
+}
+
+func lookupGroup(g string) (uint32, error) {
+	if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	grp, err := user.LookupGroup(g)
+	if err != nil {
+		return 0, fmt.Errorf("isolation group %q: %w", g, err)
+	}
+	gid, err := strconv.ParseUint(grp.Gid, 10, 32)
+	return uint32(gid), err
+}
+
+// chrootPaths maps the host executable and work dir to their paths inside root.
+func chrootPaths(root, executable, workDir string) (string, string, error) {
+	exe, ok := inRoot(root, executable)
+	if !ok {
+		return "", "", fmt.Errorf("executable %s is not inside the isolation root %s", executable, root)
+	}
+	dir, ok := inRoot(root, workDir)
+	if !ok {
+		dir = "/"
+	}
+	return exe, dir, nil
+}
+
+// chrootEnvPaths maps the plugin's env paths into the chroot; one outside it is left out.
+func chrootEnvPaths(root string, paths backend.EnvPaths) backend.EnvPaths {
+	for _, p := range []*string{&paths.SecretsDir, &paths.DataDir, &paths.LogDir, &paths.RuntimeSocket} {
+		*p, _ = inRoot(root, *p)
+	}
+	return paths
+}
+
+// inRoot returns host path p as seen from inside root, if it lies there.
+func inRoot(root, p string) (string, bool) {
+	rel, err := filepath.Rel(root, p)
+	if p == "" || err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return "/" + filepath.ToSlash(rel), true
+}
+
+// restrictThread applies the thread-scoped parts of the isolation to the calling OS thread, which
+// must be locked and must be the one that starts the plugin: the plugin inherits them at fork, the
+// rest of the shim does not. The thread's own effective capabilities are kept, so the fork can
+// still switch credentials; the exec then grants the plugin none. It returns the Landlock ABI applied.
+// secretsDir, if set, is readable under Landlock and the writable dirs are writable.
+func restrictThread(iso backend.Isolation, executable, secretsDir string, writable []string) (int, error) {
+	if iso.NoNewPrivileges {
+		if err := prctl(prSetNoNewPrivs, 1, 0); err != nil {
+			return 0, fmt.Errorf("set no_new_privs: %w", err)
+		}
+	}
+	if iso.DropCaps {
+		if err := dropCaps(); err != nil {
+			return 0, err
+		}
+	}
+	if iso.Landlock == nil {
+		return 0, nil
+	}
+	readOnly := append(append([]string{executable}, landlockSystemReadOnly...), iso.Landlock.ReadOnly...)
+	if secretsDir != "" {
+		readOnly = append(readOnly, secretsDir)
+	}
+	readWrite := append(append(writable, landlockSystemReadWrite...), iso.Landlock.ReadWrite...)
+	return landlockRestrictThread(readOnly, readWrite)
+}
+
+// dropCaps empties the calling thread's bounding, ambient and inheritable capability sets.
+func dropCaps() error {
+	last, err := lastCap()
+	if err != nil {
+		return err
+	}
+	for c := uintptr(0); c <= last; c++ {
+		if err := prctl(syscall.PR_CAPBSET_DROP, c, 0); err != nil && err != syscall.EINVAL {
+			return fmt.Errorf("drop capability %d from the bounding set: %w", c, err)
+		}
+	}
+	if err := prctl(prCapAmbient, prCapAmbientClearAll, 0); err != nil && err != syscall.EINVAL {
+		return fmt.Errorf("clear ambient capabilities: %w", err)
+	}
+	return clearInheritableCaps()
+}
+
+func prctl(option, arg2, arg3 uintptr) error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, option, arg2, arg3, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// lastCap returns the highest capability number the kernel knows.
+func lastCap() (uintptr, error) {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return 0, fmt.Errorf("read cap_last_cap: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("parse cap_last_cap: %w", err)
+	}
+	return uintptr(n), nil
+}
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// clearInheritableCaps empties the calling thread's inheritable set, keeping effective and permitted.
+func clearInheritableCaps() error {
+	hdr := capHeader{version: linuxCapabilityV3}
+	var data [2]capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capget: %w", errno)
+	}
+	data[0].inheritable, data[1].inheritable = 0, 0
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+	return nil
+}
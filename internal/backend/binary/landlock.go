@@ -0,0 +1,152 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock syscalls have the same numbers on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	oPath = 0x200000 // O_PATH, not in package syscall
+)
+
+// Filesystem access rights, by the ABI version that introduced them.
+const (
+	llExecute    = 1 << 0
+	llWriteFile  = 1 << 1
+	llReadFile   = 1 << 2
+	llReadDir    = 1 << 3
+	llRemoveDir  = 1 << 4
+	llRemoveFile = 1 << 5
+	llMakeChar   = 1 << 6
+	llMakeDir    = 1 << 7
+	llMakeReg    = 1 << 8
+	llMakeSock   = 1 << 9
+	llMakeFifo   = 1 << 10
+	llMakeBlock  = 1 << 11
+	llMakeSym    = 1 << 12
+	llRefer      = 1 << 13 // ABI 2
+	llTruncate   = 1 << 14 // ABI 3
+	llIoctlDev   = 1 << 15 // ABI 5
+
+	llReadOnly = llExecute | llReadFile | llReadDir
+	// llFileRights are the rights that apply to a file (rather than a dir) rule.
+	llFileRights = llExecute | llWriteFile | llReadFile | llTruncate | llIoctlDev
+)
+
+// System paths every Landlock-restricted plugin may read (and execute from), so dynamic binaries
+// and scripts still start; and device files it may also write.
+var (
+	landlockSystemReadOnly = []string{
+		"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc", "/proc",
+		"/dev/random", "/dev/urandom",
+	}
+	landlockSystemReadWrite = []string{"/dev/null", "/dev/zero", "/dev/full"}
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is packed in the kernel ABI: the fd directly follows the mask.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+	_             [4]byte
+}
+
+// landlockABI returns the kernel's Landlock ABI version, or 0 if Landlock is unavailable.
+func landlockABI() int {
+	v, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(v)
+}
+
+// handledAccess is every filesystem right the given ABI can restrict; newer kernels restrict more.
+func handledAccess(abi int) uint64 {
+	h := uint64(llExecute | llWriteFile | llReadFile | llReadDir | llRemoveDir | llRemoveFile | llMakeChar |
+		llMakeDir | llMakeReg | llMakeSock | llMakeFifo | llMakeBlock | llMakeSym)
+	if abi >= 2 {
+		h |= llRefer
+	}
+	if abi >= 3 {
+		h |= llTruncate
+	}
+	if abi >= 5 {
+		h |= llIoctlDev
+	}
+	return h
+}
+
+// landlockRestrictThread confines the calling (locked) OS thread, and so the plugin it forks, to
+// read-only access below readOnly and full access below readWrite. Paths that do not exist are
+// skipped. It returns the ABI applied; 0 means the kernel has no Landlock and nothing was restricted.
+// Landlock requires no_new_privs (or CAP_SYS_ADMIN), so it is set before restricting.
+func landlockRestrictThread(readOnly, readWrite []string) (int, error) {
+	abi := landlockABI()
+	if abi == 0 {
+		return 0, nil
+	}
+	handled := handledAccess(abi)
+	attr := landlockRulesetAttr{handledAccessFS: handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return abi, fmt.Errorf("landlock_create_ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+	for _, r := range []struct {
+		paths  []string
+		access uint64
+	}{
+		{readOnly, llReadOnly},
+		{readWrite, handled},
+	} {
+		for _, p := range r.paths {
+			if err := landlockAddPath(int(fd), p, r.access&handled); err != nil {
+				return abi, err
+			}
+		}
+	}
+	if err := prctl(prSetNoNewPrivs, 1, 0); err != nil {
+		return abi, fmt.Errorf("set no_new_privs: %w", err)
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return abi, fmt.Errorf("landlock_restrict_self: %w", errno)
+	}
+	return abi, nil
+}
+
+func landlockAddPath(rulesetFd int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("landlock rule %s: %w", path, err)
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("landlock rule %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= llFileRights
+	}
+	attr := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock rule %s: %w", path, os.NewSyscallError("landlock_add_rule", errno))
+	}
+	return nil
+}
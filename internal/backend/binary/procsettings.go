@@ -0,0 +1,90 @@
+package binary
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/tomatopunk/agent-runtime/internal/resources"
+)
+
+// Not in package syscall.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// applyProcessSettings applies the spec's rlimits, nice, I/O priority, real-time policy and CPU
+// affinity to the started plugin. Rlimits are per process; the rest is per thread, so it is set on
+// every thread present, and threads created later inherit it from their creator.
+func applyProcessSettings(pid int, s *resources.Spec) error {
+	for _, r := range s.Rlimits {
+		lim := syscall.Rlimit{Cur: r.Soft, Max: r.Hard}
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(r.Resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("set rlimit %s: %w", r.Name, errno)
+		}
+	}
+	if s.Nice == nil && s.IOPrioClass == 0 && s.SchedPolicy == 0 && s.CPUAffinity == "" {
+		return nil
+	}
+	tids, err := threads(pid)
+	if err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		if err := applyThreadSettings(tid, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyThreadSettings(tid int, s *resources.Spec) error {
+	if s.Nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, *s.Nice); err != nil {
+			return fmt.Errorf("set nice: %w", err)
+		}
+	}
+	if s.IOPrioClass != 0 {
+		prio := s.IOPrioClass<<ioprioClassShift | s.IOPrioLevel
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return fmt.Errorf("set io priority: %w", errno)
+		}
+	}
+	if s.SchedPolicy != 0 {
+		param := struct{ priority int32 }{int32(s.SchedPriority)}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(s.SchedPolicy), uintptr(unsafe.Pointer(&param))); errno != 0 {
+			return fmt.Errorf("set scheduling policy: %w", errno)
+		}
+	}
+	if s.CPUAffinity != "" {
+		var mask [16]uint64 // up to 1024 CPUs, like glibc's cpu_set_t
+		for _, c := range resources.CPUList(s.CPUAffinity) {
+			if c >= len(mask)*64 {
+				return fmt.Errorf("set cpu affinity: cpu %d out of range", c)
+			}
+			mask[c/64] |= 1 << (c % 64)
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask[0]))); errno != 0 {
+			return fmt.Errorf("set cpu affinity: %w", errno)
+		}
+	}
+	return nil
+}
+
+// threads lists the thread IDs of pid.
+func threads(pid int) ([]int, error) {
+	entries, err := os.ReadDir("/proc/" + strconv.Itoa(pid) + "/task")
+	if err != nil {
+		return nil, fmt.Errorf("list plugin threads: %w", err)
+	}
+	tids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
@@ -0,0 +1,42 @@
+package binary
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// shmDir is preferred for secrets dirs: a tmpfs, so the values never reach the disk.
+const shmDir = "/dev/shm"
+
+// writeSecrets creates a private dir holding the plugin's file secrets, owned by the user the plugin
+// runs as. The caller removes it once the plugin exits.
+func writeSecrets(pluginID string, secrets []backend.Secret, cred *syscall.Credential) (string, error) {
+	parent := os.TempDir()
+	if fi, err := os.Stat(shmDir); err == nil && fi.IsDir() {
+		parent = shmDir
+	}
+	dir, err := os.MkdirTemp(parent, "agent-runtime-"+pluginID+"-secrets-")
+	if err != nil {
+		return "", err
+	}
+	uid, gid := pluginOwner(cred)
+	if err := os.Chown(dir, uid, gid); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if err := backend.WriteSecretFiles(dir, secrets, uid, gid); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// pluginOwner returns the uid and gid the plugin runs as: cred's, else the shim's own.
+func pluginOwner(cred *syscall.Credential) (int, int) {
+	if cred != nil {
+		return int(cred.Uid), int(cred.Gid)
+	}
+	return os.Getuid(), os.Getgid()
+}
@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Env policies (RunOptions.EnvPolicy): which of the agent's own environment a plugin sees.
+const (
+	EnvInherit   = "inherit"   // all of it (the binary backend's default)
+	EnvClean     = "clean"     // only PATH (the runc backend's default)
+	EnvAllowlist = "allowlist" // PATH and the variables named in EnvAllow
+)
+
+// DefaultPath is PATH when the agent has none, and inside runc containers whose image sets none.
+const DefaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// EnvPaths are the runtime's paths as the plugin sees them, which is what differs between backends;
+// an empty one leaves its variable out.
+type EnvPaths struct {
+	HostDir       string // the host's / (HOST_DIR)
+	SecretsDir    string // the file secrets (PLUGIN_SECRETS_DIR)
+	DataDir       string // the plugin's persistent data dir (PLUGIN_DATA_DIR)
+	LogDir        string // a dir for the plugin's own log files (PLUGIN_LOG_DIR)
+	RuntimeSocket string // the agent's runtime API socket (PLUGIN_RUNTIME_SOCKET)
+}
+
+// PluginEnv builds a plugin's environment; both backends use it, so they differ only in the
+// arguments. From lowest to highest precedence:
+//
+//   - the agent's environment, filtered by opts.EnvPolicy;
+//   - defaults, the backend's own base (runc: PATH and the image's Env, so the host PATH never
+//     leaks into a container);
+//   - the runtime vars, which are the contract every plugin can rely on: PLUGIN_ID, PLUGIN_VERSION,
+//     DEVICE_ID, HOST_TYPE, HOST_NAME and PLUGIN_INSTANCE_START (opts.StartedAt, RFC 3339 UTC), plus
+//     HOST_DIR, PLUGIN_SECRETS_DIR, PLUGIN_DATA_DIR, PLUGIN_LOG_DIR and PLUGIN_RUNTIME_SOCKET when
+//     set in paths;
+//   - opts.EnvFiles in order, then opts.Env;
+//   - env secrets.
+//
+// A later value replaces an earlier one in place.
+func PluginEnv(opts RunOptions, defaults []string, paths EnvPaths) ([]string, error) {
+	host, err := hostEnv(opts.EnvPolicy, opts.EnvAllow)
+	if err != nil {
+		return nil, err
+	}
+	started := opts.StartedAt
+	if started.IsZero() {
+		started = time.Now()
+	}
+	vars := []string{
+		"PLUGIN_ID=" + opts.PluginID,
+		"PLUGIN_VERSION=" + opts.PluginVersion,
+		"DEVICE_ID=" + opts.DeviceId,
+		"HOST_TYPE=" + opts.HostType,
+		"HOST_NAME=" + opts.HostName,
+		"PLUGIN_INSTANCE_START=" + started.UTC().Format(time.RFC3339Nano),
+	}
+	for _, v := range []struct{ name, value string }{
+		{"HOST_DIR", paths.HostDir},
+		{"PLUGIN_SECRETS_DIR", paths.SecretsDir},
+		{"PLUGIN_DATA_DIR", paths.DataDir},
+		{"PLUGIN_LOG_DIR", paths.LogDir},
+		{"PLUGIN_RUNTIME_SOCKET", paths.RuntimeSocket},
+	} {
+		if v.value != "" {
+			vars = append(vars, v.name+"="+v.value)
+		}
+	}
+	lists := [][]string{host, defaults, vars}
+	for _, f := range opts.EnvFiles {
+		env, err := ReadEnvFile(f)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, env)
+	}
+	secrets, err := SecretEnv(opts.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	return mergeEnv(append(lists, opts.Env, secrets)...), nil
+}
+
+// MakePluginDir creates one of the plugin's writable dirs (PLUGIN_DATA_DIR, PLUGIN_LOG_DIR) if
+// missing and hands it to uid:gid, the user the plugin runs as. Its parents stay traversable.
+func MakePluginDir(dir string, uid, gid int) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0750); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Chown(dir, uid, gid)
+}
+
+// ValidateEnvPolicy checks the policy and that an allowlist is only given with EnvAllowlist.
+func ValidateEnvPolicy(policy string, allow []string) error {
+	switch policy {
+	case EnvInherit, EnvClean:
+		if len(allow) > 0 {
+			return fmt.Errorf("an env allowlist needs env policy %s, not %s", EnvAllowlist, policy)
+		}
+	case EnvAllowlist:
+	default:
+		return fmt.Errorf("invalid env policy %q: want %s | %s | %s", policy, EnvInherit, EnvClean, EnvAllowlist)
+	}
+	for _, name := range allow {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("invalid env allowlist entry %q: not a variable name", name)
+		}
+	}
+	return nil
+}
+
+// hostEnv returns the part of the agent's environment the policy passes on.
+func hostEnv(policy string, allow []string) ([]string, error) {
+	switch policy {
+	case EnvInherit:
+		return os.Environ(), nil
+	case EnvClean, EnvAllowlist:
+		env := []string{DefaultPath}
+		if v, ok := os.LookupEnv("PATH"); ok {
+			env[0] = "PATH=" + v
+		}
+		for _, name := range allow {
+			if v, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+v)
+			}
+		}
+		return env, nil
+	}
+	return nil, fmt.Errorf("invalid env policy %q", policy)
+}
+
+// ReadEnvFile reads a docker-style env file: KEY=VALUE lines, taken literally; a bare KEY copies the
+// agent's value if it has one; blank lines and lines starting with # are skipped.
+func ReadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+	var env []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimLeft(sc.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(line, "=")
+		if !envName.MatchString(name) {
+			return nil, fmt.Errorf("env file %s line %d: %q is not a variable name", path, n, name)
+		}
+		if !hasValue {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			value = v
+		}
+		env = append(env, name+"="+value)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("env file %s: %w", path, err)
+	}
+	return env, nil
+}
+
+// mergeEnv concatenates KEY=VALUE lists; a later value replaces an earlier one in place.
+func mergeEnv(lists ...[]string) []string {
+	var out []string
+	pos := map[string]int{}
+	for _, l := range lists {
+		for _, kv := range l {
+			k, _, _ := strings.Cut(kv, "=")
+			if i, ok := pos[k]; ok {
+				out[i] = kv
+				continue
+			}
+			pos[k] = len(out)
+			out = append(out, kv)
+		}
+	}
+	return out
+}
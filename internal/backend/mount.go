@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Mount types (Mount.Type).
+const (
+	MountBind   = "bind"   // a host path, which must be allowed by the runtime config
+	MountVolume = "volume" // a named dir under <root>/volumes that outlives the plugin
+)
+
+// Mount is an extra mount of a runc plugin.
+type Mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"` // host path (bind) or volume name (volume)
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"read_only,omitempty"`
+}
+
+var volumeName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidateVolumeName checks that name can name a volume dir.
+func ValidateVolumeName(name string) error {
+	if !volumeName.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q: want letters, digits, '_', '.' and '-', not starting with a symbol", name)
+	}
+	return nil
+}
+
+// ParseMount parses a docker-style mount: comma-separated type=bind|volume (default volume),
+// src|source, dst|destination|target and ro|readonly[=true|false].
+func ParseMount(s string) (Mount, error) {
+	m := Mount{Type: MountVolume}
+	for _, field := range strings.Split(s, ",") {
+		k, v, hasValue := strings.Cut(strings.TrimSpace(field), "=")
+		switch k {
+		case "type":
+			m.Type = v
+		case "src", "source":
+			m.Source = v
+		case "dst", "destination", "target":
+			m.Destination = v
+		case "ro", "readonly":
+			switch {
+			case !hasValue || v == "true" || v == "1":
+				m.ReadOnly = true
+			case v == "false" || v == "0":
+				m.ReadOnly = false
+			default:
+				return Mount{}, fmt.Errorf("invalid mount %q: %s=%s is not a boolean", s, k, v)
+			}
+		default:
+			return Mount{}, fmt.Errorf("invalid mount %q: unknown key %q", s, k)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return Mount{}, fmt.Errorf("invalid mount %q: %w", s, err)
+	}
+	return m, nil
+}
+
+// Validate checks the type, that the destination (and a bind source) is absolute and that a
+// volume source is a valid name.
+func (m Mount) Validate() error {
+	if !filepath.IsAbs(m.Destination) {
+		return fmt.Errorf("destination %q must be an absolute path", m.Destination)
+	}
+	switch m.Type {
+	case MountBind:
+		if !filepath.IsAbs(m.Source) {
+			return fmt.Errorf("bind source %q must be an absolute path", m.Source)
+		}
+	case MountVolume:
+		return ValidateVolumeName(m.Source)
+	default:
+		return fmt.Errorf("unknown mount type %q: want %s or %s", m.Type, MountBind, MountVolume)
+	}
+	return nil
+}
+
+// AllowedSource resolves a bind source and checks that it lies at or below one of the allowed host
+// paths (config.json runc.allowed_host_paths). Symlinks are resolved on both sides, so a link cannot
+// lead a bind out of the allowlist; the resolved source is returned, so what was checked is what
+// gets mounted.
+func AllowedSource(source string, allowed []string) (string, error) {
+	src, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", fmt.Errorf("bind source: %w", err)
+	}
+	for _, a := range allowed {
+		dir, err := filepath.EvalSymlinks(a)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, src); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return src, nil
+		}
+	}
+	return "", fmt.Errorf("bind source %s is not under an allowed host path (config.json runc.allowed_host_paths)", source)
+}
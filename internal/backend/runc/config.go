@@ -1,115 +1,131 @@
 package runc
 
 import (
-	"bytes"
-	"embed"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
-	"text/template"
 
 	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/image"
 )
 
-//go:embed runc.config.yaml.tpl
-var configTplFS embed.FS
-
-type configData struct {
-	BuildArgs      []string
-	HostType       string
-	HostName       string
-	RootFs         string
-	PluginId       string
-	PluginVersion  string
-	DeviceId       string
-	CPU            int   // shares
-	Memory         int64 // bytes
-	Env            []string
-}
-
-func parseCPU(s string) int {
-	if s == "" {
-		return 1024
+// writeConfigJSON renders the bundle's config.json. With an image, img supplies the process
+// defaults: Entrypoint (replaced by opts.Executable if set), Cmd (replaced by opts.Args if set),
+// Env (see backend.PluginEnv for what overrides it), WorkingDir and User. mounts are added after the
+// default mounts. The runtime-wide override and then the plugin's patches are applied on top;
+// opts.SpecFile replaces the whole thing, including the data, log and runtime socket mounts and the
+// PLUGIN_* env, so it cannot be combined with anything else that edits the spec. Either way, bind
+// mounts the runtime did not add must come from opts.AllowedHostPaths.
+func writeConfigJSON(workDir string, opts backend.RunOptions, img *image.Config, mounts []ociMount) error {
+	if opts.SpecFile != "" {
+		if len(opts.Secrets) > 0 || len(opts.SpecPatches) > 0 || len(opts.Devices) > 0 || len(opts.Mounts) > 0 || opts.HostDir {
+			return fmt.Errorf("secrets, spec patches, devices, mounts and the host dir go into the generated spec and cannot be used with a spec file")
+		}
+		b, err := os.ReadFile(opts.SpecFile)
+		if err != nil {
+			return fmt.Errorf("read spec file: %w", err)
+		}
+		if !json.Valid(b) {
+			return fmt.Errorf("spec file %s is not valid JSON", opts.SpecFile)
+		}
+		if err := checkSpecBinds(workDir, b, mounts, opts.AllowedHostPaths); err != nil {
+			return err
+		}
+		return writeConfig(workDir, b, false)
 	}
-	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	res, err := opts.Resources.Parse()
 	if err != nil {
-		return 1024
+		return err
 	}
-	if f <= 0 {
-		return 1024
+	var buildArgs, imageEnv []string
+	cwd := "/"
+	if img != nil {
+		entry, cmd := img.Entrypoint, img.Cmd
+		if opts.Executable != "" {
+			entry, cmd = []string{opts.Executable}, nil
+		}
+		if len(opts.Args) > 0 {
+			cmd = opts.Args
+		}
+		buildArgs = append(append(buildArgs, entry...), cmd...)
+		if len(buildArgs) == 0 {
+			return fmt.Errorf("image %s has no Entrypoint or Cmd; pass --executable", opts.Image)
+		}
+		imageEnv = img.Env
+		if img.WorkingDir != "" {
+			cwd = img.WorkingDir
+		}
+	} else {
+		// Process args: path inside container (after copy) + optional args
+		buildArgs = append([]string{inContainerExePath}, opts.Args...)
 	}
-	return int(f * 1024)
-}
-
-func parseMemory(s string) int64 {
-	if s == "" {
-		return 512 * 1024 * 1024 // 512Mi default
-	}
-	s = strings.TrimSpace(s)
-	s = strings.ToLower(s)
-	var mult int64 = 1
-	if strings.HasSuffix(s, "k") {
-		mult = 1024
-		s = strings.TrimSuffix(s, "k")
-	} else if strings.HasSuffix(s, "m") {
-		mult = 1024 * 1024
-		s = strings.TrimSuffix(s, "m")
-	} else if strings.HasSuffix(s, "g") {
-		mult = 1024 * 1024 * 1024
-		s = strings.TrimSuffix(s, "g")
-	}
-	n, err := strconv.ParseInt(s, 10, 64)
+	// Inside the container PATH comes from the image, else the default, never from the agent.
+	// The runtime's paths are the container's views of what pluginMounts mounts.
+	paths := backend.EnvPaths{DataDir: containerDataDir, LogDir: containerLogDir}
+	if opts.HostDir {
+		paths.HostDir = hostDirMount.Destination
+	}
+	if backend.HasSecretFiles(opts.Secrets) {
+		paths.SecretsDir = containerSecretsDir
+	}
+	if opts.RuntimeSocket != "" {
+		paths.RuntimeSocket = containerSocketPath(opts.RuntimeSocket)
+	}
+	env, err := backend.PluginEnv(opts, append([]string{backend.DefaultPath}, imageEnv...), paths)
 	if err != nil {
-		return 512 * 1024 * 1024
+		return err
 	}
-	if n <= 0 {
-		return 512 * 1024 * 1024
+	spec := defaultSpec(buildArgs, env, cwd, annotations(opts), toLinuxResources(res))
+	if img != nil && img.User != "" {
+		// Looked up in the rootfs the image was just unpacked to, before the profile adjusts it.
+		if spec.Process.User, err = imageUser(filepath.Join(workDir, rootfsDir), img.User); err != nil {
+			return err
+		}
 	}
-	return n * mult
-}
-
-func writeConfigJSON(workDir string, opts backend.RunOptions) error {
-	tplBytes, err := configTplFS.ReadFile("runc.config.yaml.tpl")
+	spec.Mounts = append(spec.Mounts, mounts...)
+	setProcessResources(spec.Process, res)
+	if err := applyDevices(spec, opts.Devices); err != nil {
+		return err
+	}
+	if err := applySecurityProfile(spec, opts.SecurityProfile); err != nil {
+		return err
+	}
+	ownDevices(spec)
+	b, err := json.MarshalIndent(spec, "", "  ")
 	if err != nil {
-		return fmt.Errorf("read runc config template: %w", err)
-	}
-	tpl, err := template.New("config").Funcs(template.FuncMap{
-		"jsonQuote": func(s string) (string, error) {
-			b, err := jsonMarshalString(s)
-			return string(b), err
-		},
-	}).Parse(string(tplBytes))
+		return fmt.Errorf("marshal runc config: %w", err)
+	}
+	var overrides []string
+	if opts.SpecOverride != "" {
+		overrides = append(overrides, opts.SpecOverride)
+	}
+	out, err := applySpecOverrides(b, append(overrides, opts.SpecPatches...))
 	if err != nil {
-		return fmt.Errorf("parse runc config template: %w", err)
-	}
-	// Process args: path inside container (after copy) + optional args
-	inContainerPath := "/app/plugin"
-	buildArgs := make([]string, 0, 1+len(opts.Args))
-	buildArgs = append(buildArgs, inContainerPath)
-	buildArgs = append(buildArgs, opts.Args...)
-	data := configData{
-		BuildArgs:     buildArgs,
-		HostType:      opts.HostType,
-		HostName:      opts.HostName,
-		RootFs:        "rootfs",
-		PluginId:      opts.PluginID,
-		PluginVersion: opts.PluginVersion,
-		DeviceId:      opts.DeviceId,
-		CPU:           parseCPU(opts.CPU),
-		Memory:        parseMemory(opts.Mem),
-		Env:           opts.Env,
-	}
-	var buf bytes.Buffer
-	if err := tpl.Execute(&buf, data); err != nil {
-		return fmt.Errorf("execute runc config template: %w", err)
-	}
-	configPath := filepath.Join(workDir, "config.json")
-	return os.WriteFile(configPath, buf.Bytes(), 0644)
+		return err
+	}
+	if err := checkSpecBinds(workDir, out, mounts, opts.AllowedHostPaths); err != nil {
+		return err
+	}
+	return writeConfig(workDir, out, backend.HasSecretEnv(opts.Secrets))
 }
 
-func jsonMarshalString(s string) ([]byte, error) {
-	// Standard JSON encoding for a string (escapes quotes etc.)
-	return []byte(strconv.Quote(s)), nil
+// writePrivate writes a file only its owner can read: config.json carries the plugin's env.
+func writePrivate(path string, b []byte) error {
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// annotations returns the plugin's labels plus the identity keys, which always win over a label.
+func annotations(opts backend.RunOptions) map[string]string {
+	a := make(map[string]string, len(opts.Labels)+3)
+	for k, v := range opts.Labels {
+		a[k] = v
+	}
+	a["plugin.id"] = opts.PluginID
+	a["plugin.version"] = opts.PluginVersion
+	a["device.id"] = opts.DeviceId
+	return a
 }
@@ -0,0 +1,177 @@
+package runc
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/image"
+)
+
+var update = flag.Bool("update", false, "rewrite the testdata golden files")
+
+// goldenOptions is a plugin with everything that must come out of config.json byte for byte:
+// quotes, newlines and markup in args, env and labels.
+func goldenOptions() backend.RunOptions {
+	return backend.RunOptions{
+		PluginID:      "golden",
+		PluginVersion: "1.2.3",
+		DeviceId:      "device-\"7\"",
+		HostType:      "edge",
+		HostName:      "host-1",
+		StartedAt:     time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		RuntimeSocket: "/run/agent/runtime.sock",
+		Executable:    "/opt/plugin/bin",
+		Args:          []string{"--name", `say "hi"`, "line1\nline2", "</script><script>alert(1)</script>", `back\slash`},
+		Env:           []string{`QUOTED="x" 'y'`, "MULTI=a\nb", "HTML=</script>&amp;"},
+		EnvPolicy:     backend.EnvClean,
+		Labels:        map[string]string{"team": "a\"b", "note": "</script>"},
+	}
+}
+
+// goldenMounts stand in for what pluginMounts adds.
+var goldenMounts = []ociMount{
+	{Destination: containerDataDir, Type: "bind", Source: "/var/lib/agent-runtime/data/golden", Options: []string{"rbind", "rw", "nosuid", "nodev"}},
+	{Destination: containerLogDir, Type: "bind", Source: "/var/lib/agent-runtime/logs/golden/plugin", Options: []string{"rbind", "rw", "nosuid", "nodev", "noexec"}},
+}
+
+// TestWriteConfigJSONGolden renders specs through writeConfigJSON and compares them with
+// testdata/<name>.golden; go test -run Golden -update rewrites the files.
+func TestWriteConfigJSONGolden(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  func(*backend.RunOptions)
+		image *image.Config
+		// passwd is written to the rootfs's /etc/passwd, for an image User to be looked up in.
+		passwd string
+	}{
+		{name: "default", opts: func(o *backend.RunOptions) { o.SecurityProfile = backend.SecurityDefault }},
+		{name: "restricted", opts: func(o *backend.RunOptions) {
+			o.SecurityProfile = backend.SecurityRestricted
+			// Owned by the restricted user in the container, not by the host's root.
+			o.Devices = []string{"/dev/null:/dev/plugin-null:rw"}
+		}},
+		{name: "privileged", opts: func(o *backend.RunOptions) {
+			o.SecurityProfile = backend.SecurityPrivileged
+			o.HostDir = true
+		}},
+		{
+			name: "image",
+			opts: func(o *backend.RunOptions) {
+				o.Image = "oci-layout:/images/app:v1"
+				o.Executable = ""
+				o.Args = nil
+			},
+			image: &image.Config{
+				Entrypoint: []string{"/bin/app", "--config=\"/etc/app.yaml\""},
+				Cmd:        []string{"serve", "</script>"},
+				Env:        []string{"PATH=/app/bin:/usr/bin", "APP_MODE=prod\ntest"},
+				WorkingDir: "/srv/app",
+				User:       "app",
+			},
+			passwd: "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/srv/app:/bin/sh\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "restricted" && goruntime.GOARCH != "amd64" {
+				t.Skip("the golden seccomp filter lists the amd64 architectures")
+			}
+			opts := goldenOptions()
+			tt.opts(&opts)
+			workDir := t.TempDir()
+			opts.WorkDir = workDir
+			if tt.passwd != "" {
+				etc := filepath.Join(workDir, rootfsDir, "etc")
+				if err := os.MkdirAll(etc, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(etc, "passwd"), []byte(tt.passwd), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeConfigJSON(workDir, opts, tt.image, goldenMounts); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(workDir, "config.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("config.json differs from %s (go test -run Golden -update rewrites it):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// TestWriteConfigJSONBinds checks that binds added by spec patches or a spec file are held to the
+// allowed host paths, and that the runtime's own binds are not.
+func TestWriteConfigJSONBinds(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	bindPatch := func(src string) string {
+		return write("patch.json", `[{"op": "add", "path": "/mounts/-", "value": {"destination": "/x", "type": "bind", "source": "`+src+`"}}]`)
+	}
+	tests := []struct {
+		name    string
+		opts    func(*backend.RunOptions)
+		wantErr bool
+	}{
+		{name: "own mounts", opts: func(o *backend.RunOptions) {}},
+		{name: "patch inside allowlist", opts: func(o *backend.RunOptions) {
+			o.SpecPatches = []string{bindPatch(allowed)}
+		}},
+		{name: "patch outside allowlist", wantErr: true, opts: func(o *backend.RunOptions) {
+			o.SpecPatches = []string{bindPatch("/etc")}
+		}},
+		{name: "rbind option outside allowlist", wantErr: true, opts: func(o *backend.RunOptions) {
+			o.SpecPatches = []string{write("none.json", `{"mounts": [{"destination": "/x", "type": "none", "source": "/etc", "options": ["rbind"]}]}`)}
+		}},
+		{name: "spec file with own mounts", opts: func(o *backend.RunOptions) {
+			o.SpecFile = write("own.json", `{"mounts": [{"destination": "/var/lib/plugin", "type": "bind", "source": "/var/lib/agent-runtime/data/golden"}]}`)
+		}},
+		{name: "spec file outside allowlist", wantErr: true, opts: func(o *backend.RunOptions) {
+			o.SpecFile = write("spec.json", `{"mounts": [{"destination": "/x", "type": "bind", "source": "/"}]}`)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := goldenOptions()
+			opts.AllowedHostPaths = []string{allowed}
+			tt.opts(&opts)
+			err := writeConfigJSON(t.TempDir(), opts, nil, goldenMounts)
+			if tt.wantErr && err == nil {
+				t.Fatal("want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
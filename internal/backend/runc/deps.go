@@ -0,0 +1,277 @@
+package runc
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tomatopunk/agent-runtime/internal/image"
+)
+
+// maxInterpreterDepth bounds script -> interpreter chains (a script whose interpreter is a script...).
+const maxInterpreterDepth = 4
+
+// ldSoConf lists the host's library dirs; defaultLibDirs are searched after them, like ld.so does.
+const ldSoConf = "/etc/ld.so.conf"
+
+var defaultLibDirs = []string{"/lib64", "/usr/lib64", "/lib", "/usr/lib"}
+
+// provisioner copies what a host executable needs to start into a bundle rootfs: for an ELF its
+// program interpreter (PT_INTERP) and shared libraries (DT_NEEDED, recursively), for a script its
+// #! interpreter and that interpreter's dependencies. Files keep their host paths inside the rootfs.
+type provisioner struct {
+	rootfs  string // bundle rootfs to copy into
+	base    string // optional base rootfs; paths it already provides are left to it
+	libDirs []string
+	class   elf.Class // of the plugin executable; libraries of another class or machine are skipped
+	machine elf.Machine
+	done    map[string]bool
+	copied  []string // container paths copied, for the log
+	// isScript is set when the plugin executable is a #! script: only its interpreter is provisioned,
+	// not the commands it runs.
+	isScript bool
+}
+
+func newProvisioner(rootfs, base string) *provisioner {
+	return &provisioner{
+		rootfs:  rootfs,
+		base:    base,
+		libDirs: append(readLdSoConf(ldSoConf, 0), defaultLibDirs...),
+		done:    map[string]bool{},
+	}
+}
+
+// executable provisions the dependencies of the host file at path (which itself is copied by the caller).
+func (p *provisioner) executable(path string, depth int) error {
+	head, err := readHead(path, 256)
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("#!")):
+		if depth == 0 {
+			p.isScript = true
+		}
+		if depth >= maxInterpreterDepth {
+			return fmt.Errorf("%s: interpreter chain too deep", path)
+		}
+		return p.script(path, head, depth)
+	case bytes.HasPrefix(head, []byte(elf.ELFMAG)):
+		return p.elf(path)
+	}
+	// Anything else (e.g. a binfmt_misc format) is left to the base rootfs.
+	return nil
+}
+
+// script provisions the #! interpreter. For "#!/usr/bin/env NAME" the program env will run is
+// looked up in the host PATH and provisioned at the same path, which the default PATH covers.
+func (p *provisioner) script(path string, head []byte, depth int) error {
+	line, _, _ := bytes.Cut(head[2:], []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return fmt.Errorf("%s: empty #! line", path)
+	}
+	interp := fields[0]
+	if !filepath.IsAbs(interp) {
+		return fmt.Errorf("%s: #! interpreter %q is not an absolute path", path, interp)
+	}
+	if err := p.install(interp, depth+1); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if filepath.Base(interp) == "env" && len(fields) > 1 && !strings.HasPrefix(fields[1], "-") {
+		prog, err := exec.LookPath(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s: #! program %q: %w", path, fields[1], err)
+		}
+		if err := p.install(prog, depth+1); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// elf provisions the program interpreter and the shared libraries of an ELF file.
+func (p *provisioner) elf(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if p.class == elf.ELFCLASSNONE {
+		p.class, p.machine = f.Class, f.Machine
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		b, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return fmt.Errorf("%s: read PT_INTERP: %w", path, err)
+		}
+		if err := p.install(string(bytes.TrimRight(b, "\x00")), 0); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	needed, err := f.ImportedLibraries()
+	if err != nil {
+		// Not dynamically linked.
+		return nil
+	}
+	dirs := p.searchDirs(f, filepath.Dir(path))
+	for _, lib := range needed {
+		found, err := p.findLibrary(lib, dirs)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := p.install(found, 0); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// searchDirs returns where ld.so looks for f's libraries: DT_RPATH (ignored if DT_RUNPATH is set),
+// DT_RUNPATH, then the system dirs. $ORIGIN is the dir of the object on the host.
+func (p *provisioner) searchDirs(f *elf.File, origin string) []string {
+	var dirs []string
+	paths, _ := f.DynString(elf.DT_RUNPATH)
+	if len(paths) == 0 {
+		paths, _ = f.DynString(elf.DT_RPATH)
+	}
+	for _, list := range paths {
+		for _, d := range strings.Split(list, ":") {
+			d = strings.NewReplacer("${ORIGIN}", origin, "$ORIGIN", origin).Replace(d)
+			if d != "" {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return append(dirs, p.libDirs...)
+}
+
+// findLibrary returns the host path of the first lib in dirs that matches the executable's ELF class and machine.
+func (p *provisioner) findLibrary(lib string, dirs []string) (string, error) {
+	if strings.Contains(lib, "/") {
+		return lib, nil
+	}
+	for _, d := range dirs {
+		candidate := filepath.Join(d, lib)
+		f, err := elf.Open(candidate)
+		if err != nil {
+			continue
+		}
+		ok := f.Class == p.class && f.Machine == p.machine
+		f.Close()
+		if ok {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("shared library %s not found on the host", lib)
+}
+
+// install copies the host file at path to the same path in the rootfs, unless the base rootfs
+// provides it, and provisions its own dependencies.
+func (p *provisioner) install(path string, depth int) error {
+	if p.done[path] {
+		return nil
+	}
+	p.done[path] = true
+	if p.base != "" {
+		if provided, err := image.SafeJoin(p.base, path); err == nil {
+			if _, err := os.Lstat(provided); err == nil {
+				return nil
+			}
+		}
+	}
+	dest, err := image.SafeJoin(p.rootfs, path)
+	if err != nil {
+		return err
+	}
+	if err := copyHostFile(path, dest); err != nil {
+		return err
+	}
+	p.copied = append(p.copied, path)
+	return p.executable(path, depth)
+}
+
+// copyHostFile copies the file at src (following symlinks) to dest, replacing what was there.
+func copyHostFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func readHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	m, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:m], nil
+}
+
+// readLdSoConf returns the library dirs listed in an ld.so.conf file, following "include" globs.
+func readLdSoConf(path string, depth int) []string {
+	f, err := os.Open(path)
+	if err != nil || depth > 8 {
+		return nil
+	}
+	defer f.Close()
+	var dirs []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if pattern, ok := strings.CutPrefix(line, "include "); ok {
+			pattern = strings.TrimSpace(pattern)
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(path), pattern)
+			}
+			matches, _ := filepath.Glob(pattern)
+			for _, m := range matches {
+				dirs = append(dirs, readLdSoConf(m, depth+1)...)
+			}
+			continue
+		}
+		if filepath.IsAbs(line) {
+			dirs = append(dirs, line)
+		}
+	}
+	return dirs
+}
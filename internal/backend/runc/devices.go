@@ -0,0 +1,133 @@
+package runc
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/tomatopunk/agent-runtime/internal/resources"
+)
+
+type ociDevice struct {
+	Path     string  `json:"path"`
+	Type     string  `json:"type"`
+	Major    int64   `json:"major"`
+	Minor    int64   `json:"minor"`
+	FileMode *uint32 `json:"fileMode,omitempty"`
+	UID      *uint32 `json:"uid,omitempty"`
+	GID      *uint32 `json:"gid,omitempty"`
+}
+
+type ociDeviceCgroup struct {
+	Allow  bool   `json:"allow"`
+	Type   string `json:"type,omitempty"`
+	Major  *int64 `json:"major,omitempty"`
+	Minor  *int64 `json:"minor,omitempty"`
+	Access string `json:"access,omitempty"`
+}
+
+// baselineDeviceRules deny every device, then allow the nodes runc always creates in /dev: null,
+// zero, full, random, urandom, tty, console, ptmx and the pts.
+func baselineDeviceRules() []ociDeviceCgroup {
+	allow := func(major, minor int64) ociDeviceCgroup {
+		r := ociDeviceCgroup{Allow: true, Type: "c", Major: &major, Access: "rwm"}
+		if minor >= 0 {
+			r.Minor = &minor
+		}
+		return r
+	}
+	return []ociDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		allow(1, 3), allow(1, 5), allow(1, 7), allow(1, 8), allow(1, 9),
+		allow(5, 0), allow(5, 1), allow(5, 2),
+		allow(136, -1),
+	}
+}
+
+// applyDevices sets the deny-by-default device cgroup and passes the plugin's devices through:
+// each becomes a node in the container, with the host's numbers, mode and owner (see ownDevices),
+// and an allow rule.
+// A device is HOST[:CONTAINER][:PERMS], PERMS a subset of rwm (default rwm); a HOST glob such as
+// /dev/ttyUSB* passes every matching node through at its own path, and may match none.
+func applyDevices(spec *ociSpec, devices []string) error {
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = &linuxResources{}
+	}
+	rules := baselineDeviceRules()
+	for _, d := range devices {
+		host, dest, perms, err := parseDevice(d)
+		if err != nil {
+			return err
+		}
+		paths := []string{host}
+		glob := strings.ContainsAny(host, "*?[")
+		if glob {
+			if paths, err = filepath.Glob(host); err != nil {
+				return fmt.Errorf("device %q: %w", d, err)
+			}
+		}
+		for _, p := range paths {
+			node, err := resources.StatDevice(p)
+			if err != nil {
+				if glob {
+					// Globs are for device classes; skip whatever else matches, e.g. a by-id dir.
+					continue
+				}
+				return fmt.Errorf("device %q: %w", d, err)
+			}
+			path := p
+			if dest != "" {
+				path = dest
+			}
+			mode, uid, gid := node.Mode, node.UID, node.GID
+			spec.Linux.Devices = append(spec.Linux.Devices, ociDevice{
+				Path: path, Type: node.Type, Major: node.Major, Minor: node.Minor,
+				FileMode: &mode, UID: &uid, GID: &gid,
+			})
+			major, minor := node.Major, node.Minor
+			rules = append(rules, ociDeviceCgroup{Allow: true, Type: node.Type, Major: &major, Minor: &minor, Access: perms})
+		}
+	}
+	spec.Linux.Resources.Devices = rules
+	return nil
+}
+
+// ownDevices hands the passed-through device nodes to the process user when it is not root, as
+// under the restricted profile or a non-root image User: the host owner and group (root, dialout,
+// ...) would lock it out, since it has no supplementary groups. The cgroup rules still limit access.
+func ownDevices(spec *ociSpec) {
+	u := spec.Process.User
+	if u.UID == 0 {
+		return
+	}
+	for i := range spec.Linux.Devices {
+		uid, gid := u.UID, u.GID
+		spec.Linux.Devices[i].UID, spec.Linux.Devices[i].GID = &uid, &gid
+	}
+}
+
+// parseDevice splits HOST[:CONTAINER][:PERMS].
+func parseDevice(s string) (host, dest, perms string, err error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	perms = "rwm"
+	if n := len(parts); n > 1 && !strings.HasPrefix(parts[n-1], "/") {
+		perms, parts = parts[n-1], parts[:n-1]
+		if perms == "" || strings.Trim(perms, "rwm") != "" {
+			return "", "", "", fmt.Errorf("invalid device %q: permissions must be a subset of rwm", s)
+		}
+	}
+	if len(parts) > 2 || !filepath.IsAbs(parts[0]) {
+		return "", "", "", fmt.Errorf("invalid device %q: want HOST[:CONTAINER][:PERMS] with absolute paths", s)
+	}
+	host = parts[0]
+	if len(parts) == 2 {
+		dest = parts[1]
+		if !filepath.IsAbs(dest) {
+			return "", "", "", fmt.Errorf("invalid device %q: container path must be absolute", s)
+		}
+		if strings.ContainsAny(host, "*?[") {
+			return "", "", "", fmt.Errorf("invalid device %q: a glob cannot be given a container path", s)
+		}
+	}
+	return host, dest, perms, nil
+}
@@ -0,0 +1,202 @@
+package runc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/backend/binary"
+	"github.com/tomatopunk/agent-runtime/internal/logs"
+	"github.com/tomatopunk/agent-runtime/internal/state"
+)
+
+// envDumpArg makes the test binary an env-dumping plugin (see TestMain): it prints each entry of
+// its environment quoted, one per line.
+const envDumpArg = "conformance-env-dump"
+
+func TestMain(m *testing.M) {
+	if len(os.Args) == 2 && os.Args[1] == envDumpArg {
+		for _, kv := range os.Environ() {
+			fmt.Println(strconv.Quote(kv))
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// backendSpecificEnv are the variables allowed to differ between the backends: paths, which each
+// backend gives as its plugin sees them, and PATH, which a container never takes from the agent.
+var backendSpecificEnv = map[string]bool{
+	"HOST_DIR":              true,
+	"PLUGIN_DATA_DIR":       true,
+	"PLUGIN_LOG_DIR":        true,
+	"PLUGIN_SECRETS_DIR":    true,
+	"PLUGIN_RUNTIME_SOCKET": true,
+	"PATH":                  true,
+}
+
+// TestEnvConformance starts the env-dumping plugin through the binary and the runc backend with the
+// same RunOptions and checks that both see the same environment, but for backendSpecificEnv.
+func TestEnvConformance(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to run containers")
+	}
+	if _, err := exec.LookPath("runc"); err != nil {
+		t.Skip("runc is not installed")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	envFile := write("plugin.env", "FROM_FILE=1\nOVERRIDDEN=file\n")
+	secret := write("token", "s3cr3t\n")
+	t.Setenv("CONFORMANCE_AGENT_VAR", "agent")
+
+	base := backend.RunOptions{
+		PluginID:      "conformance",
+		PluginVersion: "2.0.0",
+		DeviceId:      "device-1",
+		HostType:      "edge",
+		HostName:      "host-1",
+		StartedAt:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		RuntimeSocket: filepath.Join(dir, "runtime.sock"),
+		Executable:    exe,
+		Args:          []string{envDumpArg},
+		Env:           []string{"OVERRIDDEN=flag", "QUOTED=\"a b\"\nc"},
+		EnvFiles:      []string{envFile},
+		Secrets:       []backend.Secret{{Name: "cert", Source: secret}},
+	}
+	tests := []struct {
+		name string
+		opts func(*backend.RunOptions)
+	}{
+		{name: "clean", opts: func(o *backend.RunOptions) { o.EnvPolicy = backend.EnvClean }},
+		{name: "inherit", opts: func(o *backend.RunOptions) { o.EnvPolicy = backend.EnvInherit }},
+		{name: "allowlist", opts: func(o *backend.RunOptions) {
+			o.EnvPolicy = backend.EnvAllowlist
+			o.EnvAllow = []string{"CONFORMANCE_AGENT_VAR", "PATH"}
+		}},
+		{name: "host dir", opts: func(o *backend.RunOptions) {
+			o.EnvPolicy = backend.EnvClean
+			o.HostDir = true
+		}},
+		{name: "env secret", opts: func(o *backend.RunOptions) {
+			o.EnvPolicy = backend.EnvClean
+			o.Secrets = append(o.Secrets, backend.Secret{Name: "TOKEN", Source: secret, Env: true})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			tt.opts(&opts)
+			binaryRoot, runcRoot := t.TempDir(), t.TempDir()
+			binaryState, runcState := state.NewManager(binaryRoot), state.NewManager(runcRoot)
+			binaryEnv := dumpEnv(t, binary.New(binaryState, nil), binaryState, binaryRoot, backend.BackendBinary, opts)
+			runcEnv := dumpEnv(t, New(runcState, "", nil), runcState, runcRoot, backend.BackendRunc, opts)
+			compareEnv(t, binaryEnv, runcEnv)
+		})
+	}
+}
+
+// dumpEnv runs the env-dumping plugin through be, with its state under root, and returns the
+// environment it printed.
+func dumpEnv(t *testing.T, be backend.Backend, sm *state.Manager, root, name string, opts backend.RunOptions) map[string]string {
+	t.Helper()
+	opts.RootDir = root
+	opts.WorkDir = t.TempDir()
+	if err := sm.Register(state.Meta{PluginID: opts.PluginID, Backend: name, RootDir: opts.RootDir, WorkDir: opts.WorkDir}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = be.Delete(context.Background(), opts.PluginID) })
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := be.Run(ctx, opts); err != nil {
+		t.Fatalf("%s: run: %v", name, err)
+	}
+	if err := be.Wait(ctx, opts.PluginID); err != nil {
+		t.Fatalf("%s: wait: %v", name, err)
+	}
+	f, err := os.Open(sm.LogPath(opts.PluginID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var env []string
+	var line strings.Builder
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		e, tag := logs.ParseLine(sc.Text())
+		if e.Stream != logs.StreamStdout {
+			t.Logf("%s: %s", name, e.Message)
+			continue
+		}
+		line.WriteString(e.Message)
+		if tag == "P" {
+			continue
+		}
+		kv, err := strconv.Unquote(line.String())
+		if err != nil {
+			t.Fatalf("%s: plugin output %q: %v", name, line.String(), err)
+		}
+		env = append(env, kv)
+		line.Reset()
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(env) == 0 {
+		t.Fatalf("%s: the plugin printed no environment", name)
+	}
+	return envMap(t, env)
+}
+
+func envMap(t *testing.T, env []string) map[string]string {
+	t.Helper()
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			t.Fatalf("malformed env entry %q", kv)
+		}
+		if _, dup := m[k]; dup {
+			t.Errorf("%s set twice", k)
+		}
+		m[k] = v
+	}
+	return m
+}
+
+func compareEnv(t *testing.T, binaryEnv, runcEnv map[string]string) {
+	t.Helper()
+	for k, v := range binaryEnv {
+		if backendSpecificEnv[k] {
+			continue
+		}
+		if rv, ok := runcEnv[k]; !ok {
+			t.Errorf("%s=%q only in the binary env", k, v)
+		} else if rv != v {
+			t.Errorf("%s: binary %q, runc %q", k, v, rv)
+		}
+	}
+	for k, v := range runcEnv {
+		if _, ok := binaryEnv[k]; !ok && !backendSpecificEnv[k] {
+			t.Errorf("%s=%q only in the runc env", k, v)
+		}
+	}
+}
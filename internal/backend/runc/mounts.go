@@ -0,0 +1,138 @@
+package runc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// hostDirMount is the opt-in (RunOptions.HostDir) view of the host filesystem.
+var hostDirMount = ociMount{Destination: "/host", Type: "bind", Source: "/", Options: []string{"rbind", "ro", "rslave"}}
+
+// Where the runtime's own dirs appear in the container (see backend.EnvPaths).
+const (
+	containerDataDir       = "/var/lib/plugin"
+	containerLogDir        = "/var/log/plugin"
+	containerRuntimeSocket = "/run/agent-runtime"
+)
+
+// containerSocketPath is the runtime socket's path in the container: its dir is mounted, not the
+// socket itself, so a socket the agent recreates stays reachable.
+func containerSocketPath(socket string) string {
+	return filepath.Join(containerRuntimeSocket, filepath.Base(socket))
+}
+
+// pluginMounts returns the plugin's mounts on top of the defaults: its data and log dirs, the
+// runtime socket's dir if configured, the host dir if asked for, the secrets dir if it has file
+// secrets, then its binds and volumes in order. Bind sources are the runtime's resolved ones; volume
+// dirs are made by makeVolumes.
+func (b *Backend) pluginMounts(opts backend.RunOptions) []ociMount {
+	mounts := []ociMount{
+		{Destination: containerDataDir, Type: "bind", Source: b.state.DataDir(opts.PluginID), Options: []string{"rbind", "rw", "nosuid", "nodev"}},
+		{Destination: containerLogDir, Type: "bind", Source: b.state.PluginLogDir(opts.PluginID), Options: []string{"rbind", "rw", "nosuid", "nodev", "noexec"}},
+	}
+	if opts.RuntimeSocket != "" {
+		mounts = append(mounts, ociMount{Destination: containerRuntimeSocket, Type: "bind", Source: filepath.Dir(opts.RuntimeSocket), Options: []string{"rbind", "ro", "nosuid", "nodev", "noexec"}})
+	}
+	if opts.HostDir {
+		mounts = append(mounts, hostDirMount)
+	}
+	if backend.HasSecretFiles(opts.Secrets) {
+		mounts = append(mounts, secretsMount(opts.WorkDir))
+	}
+	for _, m := range opts.Mounts {
+		src := m.Source
+		if m.Type == backend.MountVolume {
+			src = b.state.VolumeDir(m.Source)
+		}
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		mounts = append(mounts, ociMount{Destination: m.Destination, Type: "bind", Source: src, Options: []string{"rbind", mode}})
+	}
+	return mounts
+}
+
+// isBind reports whether m is a bind mount, by type or, as runc also accepts, by option.
+func isBind(m ociMount) bool {
+	if m.Type == "bind" {
+		return true
+	}
+	for _, o := range m.Options {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSpecBinds checks every bind mount of the final config.json, after the spec overrides or from
+// a spec file, against the allowed host paths, so neither can bind what --mount could not. The
+// runtime's own binds (the default mounts and own, the plugin mounts it added) are left out.
+func checkSpecBinds(workDir string, spec []byte, own []ociMount, allowed []string) error {
+	var s struct {
+		Mounts []ociMount `json:"mounts"`
+	}
+	if err := json.Unmarshal(spec, &s); err != nil {
+		return fmt.Errorf("parse runc config: %w", err)
+	}
+	type bind struct{ source, destination string }
+	known := make(map[bind]bool)
+	for _, m := range append(defaultMounts(), own...) {
+		if isBind(m) {
+			known[bind{m.Source, m.Destination}] = true
+		}
+	}
+	for _, m := range s.Mounts {
+		if !isBind(m) || known[bind{m.Source, m.Destination}] {
+			continue
+		}
+		src := m.Source
+		if !filepath.IsAbs(src) {
+			// runc takes a relative bind source from the bundle.
+			src = filepath.Join(workDir, src)
+		}
+		if _, err := backend.AllowedSource(src, allowed); err != nil {
+			return fmt.Errorf("spec mount %s: %w", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+// makeVolumes creates the plugin's named volumes that do not exist yet, owned by the container's
+// process user as its data dir is. An existing volume, which other plugins may share, keeps its
+// owner; volumes are kept after the plugin.
+func (b *Backend) makeVolumes(mounts []backend.Mount, uid, gid int) error {
+	for _, m := range mounts {
+		if m.Type != backend.MountVolume {
+			continue
+		}
+		dir := b.state.VolumeDir(m.Source)
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return err
+		}
+		if err := os.Mkdir(dir, 0755); os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("create volume %s: %w", m.Source, err)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("create volume %s: %w", m.Source, err)
+		}
+	}
+	return nil
+}
+
+// makePluginDirs creates the plugin's data and log dirs, owned by the container's process user.
+func (b *Backend) makePluginDirs(pluginID string, uid, gid int) error {
+	for _, dir := range []string{b.state.DataDir(pluginID), b.state.PluginLogDir(pluginID)} {
+		if err := backend.MakePluginDir(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package runc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// applySpecOverrides applies override files to a rendered config.json, in order. A file holding a
+// JSON object is a merge patch (RFC 7386: objects merge, anything else replaces, null deletes); a
+// file holding an array is a JSON patch (RFC 6902), which can also edit lists such as mounts.
+func applySpecOverrides(spec []byte, files []string) ([]byte, error) {
+	if len(files) == 0 {
+		return spec, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parse generated spec: %w", err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read spec override: %w", err)
+		}
+		var patch interface{}
+		if err := json.Unmarshal(b, &patch); err != nil {
+			return nil, fmt.Errorf("parse spec override %s: %w", f, err)
+		}
+		switch p := patch.(type) {
+		case map[string]interface{}:
+			doc = mergePatch(doc, p)
+		case []interface{}:
+			if doc, err = jsonPatch(doc, p); err != nil {
+				return nil, fmt.Errorf("spec override %s: %w", f, err)
+			}
+		default:
+			return nil, fmt.Errorf("spec override %s: want a JSON object (merge patch) or array (JSON patch)", f)
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// mergePatch applies an RFC 7386 merge patch to target.
+func mergePatch(target interface{}, patch map[string]interface{}) interface{} {
+	obj, ok := target.(map[string]interface{})
+	if !ok {
+		obj = map[string]interface{}{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(obj, k)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			obj[k] = mergePatch(obj[k], sub)
+			continue
+		}
+		obj[k] = v
+	}
+	return obj
+}
+
+// jsonPatch applies RFC 6902 operations to doc.
+func jsonPatch(doc interface{}, ops []interface{}) (interface{}, error) {
+	for i, o := range ops {
+		op, ok := o.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d: not an object", i)
+		}
+		name, _ := op["op"].(string)
+		path, ok := op["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("operation %d: missing path", i)
+		}
+		var err error
+		switch name {
+		case "add":
+			doc, err = pointerSet(doc, path, op["value"], true)
+		case "remove":
+			doc, _, err = pointerRemove(doc, path)
+		case "replace":
+			if _, err = pointerGet(doc, path); err == nil {
+				doc, err = pointerSet(doc, path, op["value"], false)
+			}
+		case "move", "copy":
+			from, _ := op["from"].(string)
+			var v interface{}
+			if name == "move" {
+				doc, v, err = pointerRemove(doc, from)
+			} else if v, err = pointerGet(doc, from); err == nil {
+				v = deepCopy(v)
+			}
+			if err == nil {
+				doc, err = pointerSet(doc, path, v, true)
+			}
+		case "test":
+			var v interface{}
+			if v, err = pointerGet(doc, path); err == nil && !reflect.DeepEqual(v, op["value"]) {
+				err = fmt.Errorf("test failed at %s", path)
+			}
+		default:
+			err = fmt.Errorf("unsupported op %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, name, path, err)
+		}
+	}
+	return doc, nil
+}
+
+// splitPointer splits a JSON pointer (RFC 6901) into unescaped tokens.
+func splitPointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", p)
+	}
+	toks := strings.Split(p[1:], "/")
+	for i, t := range toks {
+		toks[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return toks, nil
+}
+
+// arrayIndex parses an array index token; "-" (append) is allowed only when adding.
+func arrayIndex(tok string, n int, adding bool) (int, error) {
+	if tok == "-" && adding {
+		return n, nil
+	}
+	// Only plain decimal digits without leading zeros (RFC 6901), not "+1" or "01".
+	i, err := strconv.Atoi(tok)
+	if err == nil && (tok[0] < '0' || tok[0] > '9' || (len(tok) > 1 && tok[0] == '0')) {
+		err = strconv.ErrSyntax
+	}
+	hi := n - 1
+	if adding {
+		hi = n
+	}
+	if err != nil || i < 0 || i > hi {
+		return 0, fmt.Errorf("array index %q out of range", tok)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, path string) (interface{}, error) {
+	toks, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, t := range toks {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("%s: no member %q", path, t)
+			}
+			cur = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			cur = c[i]
+		default:
+			return nil, fmt.Errorf("%s: %q is not in a container", path, t)
+		}
+	}
+	return cur, nil
+}
+
+// pointerSet sets the value at path; with insert, an array index inserts instead of replacing.
+// It returns the (possibly new) root.
+func pointerSet(doc interface{}, path string, v interface{}, insert bool) (interface{}, error) {
+	toks, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return v, nil
+	}
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, err := pointerGet(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	last := toks[len(toks)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		c[last] = v
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(c), insert)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if !insert {
+			c[i] = v
+			return doc, nil
+		}
+		c = append(c[:i], append([]interface{}{v}, c[i:]...)...)
+		return pointerSet(doc, parentPath, c, false)
+	}
+	return nil, fmt.Errorf("%s: parent is not a container", path)
+}
+
+// pointerRemove removes the value at path and returns the new root and the removed value.
+func pointerRemove(doc interface{}, path string) (interface{}, interface{}, error) {
+	toks, err := splitPointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(toks) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	old, err := pointerGet(doc, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, _ := pointerGet(doc, parentPath)
+	last := toks[len(toks)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		delete(c, last)
+		return doc, old, nil
+	case []interface{}:
+		i, _ := arrayIndex(last, len(c), false)
+		c = append(c[:i:i], c[i+1:]...)
+		doc, err = pointerSet(doc, parentPath, c, false)
+		return doc, old, err
+	}
+	return nil, nil, fmt.Errorf("%s: parent is not a container", path)
+}
+
+func deepCopy(v interface{}) interface{} {
+	b, _ := json.Marshal(v)
+	var out interface{}
+	_ = json.Unmarshal(b, &out)
+	return out
+}
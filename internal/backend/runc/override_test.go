@@ -0,0 +1,269 @@
+package runc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func parseJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("parse %s: %v", s, err)
+	}
+	return v
+}
+
+// TestJSONPatch runs the examples of RFC 6902 appendix A. A.13 (a duplicate "op" member) is left
+// out: encoding/json keeps the last member, so the patch never sees the duplicate.
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string // ignored when wantErr
+		wantErr bool
+	}{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			want:  `{"foo": "bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:    "A.9 testing a value: error",
+			doc:     `{"baz": "qux"}`,
+			patch:   `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want:  `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:    "A.12 adding to a nonexistent target",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want:  `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:    "A.15 comparing strings and numbers",
+			doc:     `{"/": 9, "~1": 10}`,
+			patch:   `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo": ["bar"]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:  `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		{
+			name:  "copy",
+			doc:   `{"a": {"b": [1]}}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "add", "path": "/c/b/-", "value": 2}]`,
+			want:  `{"a": {"b": [1]}, "c": {"b": [1, 2]}}`,
+		},
+		{
+			name:    "replacing a missing member",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "replace", "path": "/baz", "value": "qux"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "removing past the end of an array",
+			doc:     `{"foo": ["bar"]}`,
+			patch:   `[{"op": "remove", "path": "/foo/1"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "index with a leading zero",
+			doc:     `{"foo": ["bar", "baz"]}`,
+			patch:   `[{"op": "remove", "path": "/foo/01"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown op",
+			doc:     `{}`,
+			patch:   `[{"op": "merge", "path": "/a", "value": 1}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonPatch(parseJSON(t, tt.doc), parseJSON(t, tt.patch).([]interface{}))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := parseJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestMergePatch runs the examples of RFC 7386 appendix A whose patch is an object; an override
+// file holding anything else is not a merge patch.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+	for _, tt := range tests {
+		got := mergePatch(parseJSON(t, tt.target), parseJSON(t, tt.patch).(map[string]interface{}))
+		if want := parseJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge %s into %s: got %v, want %v", tt.patch, tt.target, got, want)
+		}
+	}
+}
+
+// TestPointerGet runs the examples of RFC 6901 section 5.
+func TestPointerGet(t *testing.T) {
+	doc := parseJSON(t, `{
+		"foo": ["bar", "baz"],
+		"": 0,
+		"a/b": 1,
+		"c%d": 2,
+		"e^f": 3,
+		"g|h": 4,
+		"i\\j": 5,
+		"k\"l": 6,
+		" ": 7,
+		"m~n": 8
+	}`)
+	tests := []struct {
+		pointer string
+		want    interface{}
+	}{
+		{"", doc},
+		{"/foo", []interface{}{"bar", "baz"}},
+		{"/foo/0", "bar"},
+		{"/", 0.0},
+		{"/a~1b", 1.0},
+		{"/c%d", 2.0},
+		{"/e^f", 3.0},
+		{"/g|h", 4.0},
+		{`/i\j`, 5.0},
+		{`/k"l`, 6.0},
+		{"/ ", 7.0},
+		{"/m~0n", 8.0},
+	}
+	for _, tt := range tests {
+		got, err := pointerGet(doc, tt.pointer)
+		if err != nil {
+			t.Errorf("%q: %v", tt.pointer, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.pointer, got, tt.want)
+		}
+	}
+	for _, p := range []string{"foo", "/missing", "/foo/2", "/foo/-", "/foo/+1", "/foo/0/x"} {
+		if got, err := pointerGet(doc, p); err == nil {
+			t.Errorf("%q: want an error, got %v", p, got)
+		}
+	}
+}
+
+func TestApplySpecOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	merge := write("merge.json", `{"process": {"terminal": true, "cwd": null}, "hostname": "plugin"}`)
+	patch := write("patch.json", `[{"op": "add", "path": "/mounts/-", "value": {"destination": "/data"}}, {"op": "remove", "path": "/mounts/0"}]`)
+	spec := `{"process": {"terminal": false, "cwd": "/"}, "mounts": [{"destination": "/proc"}]}`
+	out, err := applySpecOverrides([]byte(spec), []string{merge, patch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := parseJSON(t, `{"process": {"terminal": true}, "hostname": "plugin", "mounts": [{"destination": "/data"}]}`)
+	if got := parseJSON(t, string(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s", out)
+	}
+	for _, bad := range []string{write("scalar.json", `"x"`), write("invalid.json", `{`), filepath.Join(dir, "missing.json")} {
+		if _, err := applySpecOverrides([]byte(spec), []string{bad}); err == nil {
+			t.Errorf("%s: want an error", filepath.Base(bad))
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package runc
+
+import (
+	"strconv"
+
+	"github.com/tomatopunk/agent-runtime/internal/resources"
+)
+
+// linuxResources mirrors the OCI runtime spec linux.resources object (only the fields we set).
+type linuxResources struct {
+	CPU     *linuxCPU         `json:"cpu,omitempty"`
+	Memory  *linuxMemory      `json:"memory,omitempty"`
+	Pids    *linuxPids        `json:"pids,omitempty"`
+	BlockIO *linuxBlockIO     `json:"blockIO,omitempty"`
+	Unified map[string]string `json:"unified,omitempty"`
+	Devices []ociDeviceCgroup `json:"devices,omitempty"`
+}
+
+type linuxCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+	Mems   string  `json:"mems,omitempty"`
+}
+
+type linuxMemory struct {
+	Limit *int64 `json:"limit,omitempty"`
+	// Swap is memory+swap, as in the OCI spec; runc converts it for cgroup v2.
+	Swap *int64 `json:"swap,omitempty"`
+}
+
+type linuxPids struct {
+	Limit int64 `json:"limit"`
+}
+
+type linuxBlockIO struct {
+	Weight                  *uint16             `json:"weight,omitempty"`
+	ThrottleReadBpsDevice   []linuxThrottleRate `json:"throttleReadBpsDevice,omitempty"`
+	ThrottleWriteBpsDevice  []linuxThrottleRate `json:"throttleWriteBpsDevice,omitempty"`
+	ThrottleReadIOPSDevice  []linuxThrottleRate `json:"throttleReadIOPSDevice,omitempty"`
+	ThrottleWriteIOPSDevice []linuxThrottleRate `json:"throttleWriteIOPSDevice,omitempty"`
+}
+
+type linuxThrottleRate struct {
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+// OCI names of the ioprio classes and real-time policies (see resources.Spec).
+var (
+	ociIOPrioClasses = map[int]string{1: "IOPRIO_CLASS_RT", 2: "IOPRIO_CLASS_BE", 3: "IOPRIO_CLASS_IDLE"}
+	ociSchedPolicies = map[int]string{1: "SCHED_FIFO", 2: "SCHED_RR"}
+)
+
+// setProcessResources maps the spec's per-process settings to process.rlimits, process.scheduler
+// and process.ioPriority.
+func setProcessResources(p *ociProcess, s *resources.Spec) {
+	for _, r := range s.Rlimits {
+		p.Rlimits = append(p.Rlimits, ociRlimit{Type: r.OCIType(), Hard: r.Hard, Soft: r.Soft})
+	}
+	if s.SchedPolicy != 0 || s.Nice != nil {
+		p.Scheduler = &ociScheduler{Policy: "SCHED_OTHER"}
+		if s.SchedPolicy != 0 {
+			p.Scheduler.Policy = ociSchedPolicies[s.SchedPolicy]
+			p.Scheduler.Priority = int32(s.SchedPriority)
+		}
+		if s.Nice != nil {
+			p.Scheduler.Nice = int32(*s.Nice)
+		}
+	}
+	if s.IOPrioClass != 0 {
+		p.IOPriority = &ociIOPriority{Class: ociIOPrioClasses[s.IOPrioClass], Priority: s.IOPrioLevel}
+	}
+}
+
+// DefaultMemoryLimit is the memory.max of runc plugins that set no memory limit at all, the cap
+// they have always had.
+const DefaultMemoryLimit int64 = 512 << 20
+
+// toLinuxResources maps the parsed spec to OCI linux.resources.
+// memory.high and memory.min have no OCI field and go through the cgroup v2 "unified" map.
+func toLinuxResources(s *resources.Spec) *linuxResources {
+	r := &linuxResources{}
+	cpus := s.CpusetCPUs
+	if cpus == "" {
+		// A container's CPU affinity is its cpuset; an explicit cpuset wins.
+		cpus = s.CPUAffinity
+	}
+	if s.CPUQuota > 0 || s.CPUShares > 0 || cpus != "" || s.CpusetMems != "" {
+		r.CPU = &linuxCPU{Cpus: cpus, Mems: s.CpusetMems}
+		if s.CPUQuota > 0 {
+			quota, period := s.CPUQuota, s.CPUPeriod
+			r.CPU.Quota, r.CPU.Period = &quota, &period
+		}
+		if s.CPUShares > 0 {
+			shares := s.CPUShares
+			r.CPU.Shares = &shares
+		}
+	}
+	memoryMax := s.MemoryMax
+	if memoryMax == 0 && s.MemoryHigh == 0 && s.MemoryMin == 0 {
+		memoryMax = DefaultMemoryLimit
+	}
+	if memoryMax > 0 {
+		limit := memoryMax
+		r.Memory = &linuxMemory{Limit: &limit}
+		if s.MemorySwap > 0 {
+			swap := s.MemoryMax + s.MemorySwap
+			r.Memory.Swap = &swap
+		}
+	}
+	if s.PidsMax > 0 {
+		r.Pids = &linuxPids{Limit: s.PidsMax}
+	}
+	if s.IOWeight > 0 || len(s.IOLimits) > 0 {
+		r.BlockIO = &linuxBlockIO{}
+		if s.IOWeight > 0 {
+			w := s.IOWeight
+			r.BlockIO.Weight = &w
+		}
+		for _, l := range s.IOLimits {
+			add := func(dst *[]linuxThrottleRate, rate uint64) {
+				if rate > 0 {
+					*dst = append(*dst, linuxThrottleRate{Major: l.Major, Minor: l.Minor, Rate: rate})
+				}
+			}
+			add(&r.BlockIO.ThrottleReadBpsDevice, l.ReadBps)
+			add(&r.BlockIO.ThrottleWriteBpsDevice, l.WriteBps)
+			add(&r.BlockIO.ThrottleReadIOPSDevice, l.ReadIOPS)
+			add(&r.BlockIO.ThrottleWriteIOPSDevice, l.WriteIOPS)
+		}
+	}
+	if s.MemoryHigh > 0 || s.MemoryMin > 0 {
+		r.Unified = map[string]string{}
+		if s.MemoryHigh > 0 {
+			r.Unified["memory.high"] = strconv.FormatInt(s.MemoryHigh, 10)
+		}
+		if s.MemoryMin > 0 {
+			r.Unified["memory.min"] = strconv.FormatInt(s.MemoryMin, 10)
+		}
+	}
+	return r
+}
@@ -0,0 +1,53 @@
+package runc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Per-bundle overlay dirs: the plugin's writes land in the upper dir, the shared lower layers stay untouched.
+const (
+	rootfsDir    = "rootfs"
+	overlayUpper = ".rootfs-upper"
+	overlayWork  = ".rootfs-work"
+)
+
+// mountRootfs overlays the read-only lower dirs (topmost first) at <bundle>/rootfs with a writable
+// upper dir in the bundle. A mount left over from a previous run is replaced.
+func mountRootfs(bundle string, lowers []string) error {
+	for _, l := range lowers {
+		// overlayfs option syntax has no escaping for these.
+		if strings.ContainsAny(l, ":,") {
+			return fmt.Errorf("rootfs layer path %q must not contain ':' or ','", l)
+		}
+	}
+	target := filepath.Join(bundle, rootfsDir)
+	upper := filepath.Join(bundle, overlayUpper)
+	work := filepath.Join(bundle, overlayWork)
+	for _, d := range []string{target, upper, work} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	if err := unmountRootfs(bundle); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowers, ":"), upper, work)
+	if err := syscall.Mount("overlay", target, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mount rootfs overlay: %w", err)
+	}
+	return nil
+}
+
+// unmountRootfs removes the overlay at <bundle>/rootfs, if any.
+func unmountRootfs(bundle string) error {
+	err := syscall.Unmount(filepath.Join(bundle, rootfsDir), syscall.MNT_DETACH)
+	if err == nil || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return fmt.Errorf("unmount rootfs: %w", err)
+}
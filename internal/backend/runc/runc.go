@@ -1,6 +1,7 @@
 package runc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,40 +14,25 @@ import (
 	"time"
 
 	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/image"
+	"github.com/tomatopunk/agent-runtime/internal/logger"
+	"github.com/tomatopunk/agent-runtime/internal/logs"
 	"github.com/tomatopunk/agent-runtime/internal/state"
+	"github.com/tomatopunk/agent-runtime/internal/verify"
+	"go.uber.org/zap"
 )
 
 // copyExecutableToRootfs copies the host binary into bundle rootfs so runc can run it inside the container.
 func copyExecutableToRootfs(workDir, hostExecutable string) error {
-	src, err := os.Open(hostExecutable)
+	// rootfs/app/plugin; resolved inside the rootfs, which may be an overlay on a base rootfs.
+	destPath, err := image.SafeJoin(filepath.Join(workDir, rootfsDir), inContainerExePath)
 	if err != nil {
-		return fmt.Errorf("open executable %s: %w", hostExecutable, err)
-	}
-	defer src.Close()
-	info, err := src.Stat()
-	if err != nil {
-		return err
-	}
-	if !info.Mode().IsRegular() {
-		return fmt.Errorf("executable %s is not a regular file", hostExecutable)
-	}
-	// rootfs/app/plugin
-	destDir := filepath.Join(workDir, "rootfs", "app")
-	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return err
 	}
-	destPath := filepath.Join(destDir, "plugin")
-	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(dest, src)
-	dest.Close()
-	if err != nil {
-		os.Remove(destPath)
-		return err
+	if err := copyHostFile(hostExecutable, destPath); err != nil {
+		return fmt.Errorf("copy executable %s: %w", hostExecutable, err)
 	}
-	return nil
+	return os.Chmod(destPath, 0755)
 }
 
 // runcState is (partial) output of runc state
@@ -59,66 +45,194 @@ type runcState struct {
 type Backend struct {
 	state    *state.Manager
 	runcPath string
+	log      *zap.Logger
 	mu       sync.Mutex
 	cancels  map[string]context.CancelFunc
+	// done has a channel per container this process runs, closed once runc run has returned and the
+	// bundle's secrets, config and log pipeline are cleaned up.
+	done map[string]chan struct{}
 }
 
-func New(stateManager *state.Manager, runcPath string) *Backend {
+func New(stateManager *state.Manager, runcPath string, log *zap.Logger) *Backend {
 	if runcPath == "" {
 		runcPath = "runc"
 	}
+	if log == nil {
+		log = logger.NewNop()
+	}
 	return &Backend{
 		state:    stateManager,
 		runcPath: runcPath,
+		log:      log,
 		cancels:  make(map[string]context.CancelFunc),
+		done:     make(map[string]chan struct{}),
 	}
 }
 
+// pluginLog returns the backend logger with the plugin's fields.
+func (b *Backend) pluginLog(pluginID string) *zap.Logger {
+	return logger.ForPlugin(b.log, pluginID, backend.BackendRunc)
+}
+
 // Path inside container where the executable is copied (under rootfs).
 const inContainerExePath = "/app/plugin"
 
+// bareScriptHint explains why a script plugin without a base rootfs fails on its first external
+// command (test/loop-test.sh's date and sleep, for one).
+const bareScriptHint = "only the script's interpreter and its libraries are provisioned; commands it runs (date, sleep, ...) need --base-rootfs (e.g. an unpacked busybox)"
+
 func (b *Backend) Run(ctx context.Context, opts backend.RunOptions) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if opts.PluginID == "" || opts.WorkDir == "" {
 		return fmt.Errorf("plugin_id and work_dir (bundle path) are required")
 	}
-	if opts.Executable == "" {
-		return fmt.Errorf("executable (host path to binary) is required for runc")
+	if opts.Executable == "" && opts.Image == "" {
+		return fmt.Errorf("executable (host path to binary) or image is required for runc")
 	}
 	if err := os.MkdirAll(opts.WorkDir, 0755); err != nil {
 		return err
 	}
-	// Copy host executable into bundle rootfs so container can run it
-	if err := copyExecutableToRootfs(opts.WorkDir, opts.Executable); err != nil {
+	if opts.BaseRootfs != "" {
+		if fi, err := os.Stat(opts.BaseRootfs); err != nil || !fi.IsDir() || !filepath.IsAbs(opts.BaseRootfs) {
+			return fmt.Errorf("base rootfs %q must be an absolute path to a dir", opts.BaseRootfs)
+		}
+	}
+	var imgConfig *image.Config
+	// bareScript is a script plugin in a rootfs without a base: the commands it runs are missing.
+	var bareScript bool
+	if opts.Image != "" {
+		img, err := b.prepareImage(opts)
+		if err != nil {
+			return err
+		}
+		imgConfig = &img.Config
+	} else {
+		// With a base rootfs the bundle rootfs is an overlay on it; otherwise a plain dir (drop any
+		// overlay a previous run with a base left behind).
+		if opts.BaseRootfs != "" {
+			if err := mountRootfs(opts.WorkDir, []string{opts.BaseRootfs}); err != nil {
+				return err
+			}
+		} else if err := unmountRootfs(opts.WorkDir); err != nil {
+			return err
+		}
+		// Copy host executable into bundle rootfs so container can run it
+		if err := copyExecutableToRootfs(opts.WorkDir, opts.Executable); err != nil {
+			return err
+		}
+		// The copy is what runs: check it still has the digest the runtime verified.
+		if opts.Digest != "" {
+			if _, err := verify.Executable(filepath.Join(opts.WorkDir, "rootfs", inContainerExePath), verify.Options{Digest: opts.Digest}); err != nil {
+				return err
+			}
+		}
+		// Interpreter and shared libraries, so dynamic binaries and scripts start in the bare rootfs.
+		p := newProvisioner(filepath.Join(opts.WorkDir, rootfsDir), opts.BaseRootfs)
+		if err := p.executable(opts.Executable, 0); err != nil {
+			return fmt.Errorf("provision rootfs: %w", err)
+		}
+		if len(p.copied) > 0 {
+			b.pluginLog(opts.PluginID).Debug("provisioned rootfs", zap.Strings("files", p.copied))
+		}
+		if bareScript = p.isScript && opts.BaseRootfs == ""; bareScript {
+			b.pluginLog(opts.PluginID).Warn("script plugin without a base rootfs: " + bareScriptHint)
+		}
+	}
+	if err := writeConfigJSON(opts.WorkDir, opts, imgConfig, b.pluginMounts(opts)); err != nil {
 		return err
 	}
-	if err := writeConfigJSON(opts.WorkDir, opts); err != nil {
+	uid, gid, err := configUser(opts.WorkDir)
+	if err != nil {
 		return err
 	}
-	logPath := b.logPath(opts.PluginID)
-	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+	if err := b.makePluginDirs(opts.PluginID, uid, gid); err != nil {
 		return err
 	}
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err := b.makeVolumes(opts.Mounts, uid, gid); err != nil {
+		return err
+	}
+	if err := mountSecrets(opts.WorkDir, opts.Secrets, uid, gid); err != nil {
+		return err
+	}
+	// The shim owns the output pipes and runs them through the log pipeline; runc hands the pipes to the container.
+	out, err := logs.NewPipeline(logs.DriverConfig{
+		Names:    opts.LogDrivers,
+		Options:  opts.LogOpts,
+		PluginID: opts.PluginID,
+		DeviceID: opts.DeviceId,
+		FilePath: b.state.LogPath(opts.PluginID),
+		Socket:   b.state.LogSocket(opts.PluginID),
+		Rotation: opts.LogRotation,
+		Limits:   opts.LogLimits,
+		OnDropped: func(total int64) {
+			if err := b.state.WriteLogDropped(opts.PluginID, total); err != nil {
+				b.pluginLog(opts.PluginID).Warn("record dropped log lines failed", zap.Error(err))
+			}
+		},
+		OnError: func(err error) {
+			b.pluginLog(opts.PluginID).Warn("plugin log driver failed", zap.Error(err))
+		},
+	})
 	if err != nil {
 		return err
 	}
 	cmd := exec.CommandContext(ctx, b.runcPath, "run", opts.PluginID)
 	cmd.Dir = opts.WorkDir
 	cmd.Env = os.Environ()
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	cmd.Stdout = out.Stdout()
+	cmd.Stderr = out.Stderr()
+	log := b.pluginLog(opts.PluginID)
+	done := make(chan struct{})
+	b.done[opts.PluginID] = done
 	go func() {
-		defer logFile.Close()
-		_ = cmd.Run()
+		defer close(done)
+		// runc's own errors reach the plugin's stderr; the exit status only shows up here.
+		if err := cmd.Run(); err != nil && ctx.Err() == nil {
+			if bareScript {
+				log.Error("runc run failed", zap.Error(err), zap.String("hint", bareScriptHint))
+			} else {
+				log.Error("runc run failed", zap.Error(err))
+			}
+		} else {
+			log.Info("runc run exited")
+		}
+		if err := unmountSecrets(opts.WorkDir); err != nil {
+			log.Warn("drop secrets failed", zap.Error(err))
+		}
+		if err := unmountConfig(opts.WorkDir); err != nil {
+			log.Warn("drop config with env secrets failed", zap.Error(err))
+		}
+		if err := out.Close(); err != nil {
+			log.Warn("close log pipeline failed", zap.Error(err))
+		}
 	}()
-	time.Sleep(500 * time.Millisecond)
 	return nil
 }
 
-func (b *Backend) logPath(pluginID string) string {
-	return filepath.Join(b.state.StateDir(), "..", "logs", pluginID, "stdout.log")
+// prepareImage resolves the plugin's image, unpacks it into the shared snapshot dir (once per image)
+// and overlays the snapshot, above the base rootfs if one is set, at the bundle rootfs.
+func (b *Backend) prepareImage(opts backend.RunOptions) (*image.Image, error) {
+	img, err := image.Resolve(opts.Image)
+	if err != nil {
+		return nil, err
+	}
+	// The runtime verified the manifest digest; refuse a layout that changed since.
+	if opts.Digest != "" && img.Digest != opts.Digest {
+		return nil, fmt.Errorf("image %s changed since verification: want %s, got %s", opts.Image, opts.Digest, img.Digest)
+	}
+	lower, err := image.Unpack(img, b.state.SnapshotsDir())
+	if err != nil {
+		return nil, err
+	}
+	lowers := []string{lower}
+	if opts.BaseRootfs != "" {
+		lowers = append(lowers, opts.BaseRootfs)
+	}
+	if err := mountRootfs(opts.WorkDir, lowers); err != nil {
+		return nil, err
+	}
+	return img, nil
 }
 
 func (b *Backend) Stop(ctx context.Context, pluginID string) error {
@@ -126,17 +240,48 @@ func (b *Backend) Stop(ctx context.Context, pluginID string) error {
 	if err != nil {
 		return err
 	}
+	log := b.pluginLog(pluginID)
 	cmd := exec.CommandContext(ctx, b.runcPath, "delete", "--force", pluginID)
 	cmd.Dir = meta.WorkDir
-	_ = cmd.Run()
+	// Not fatal: the container may already be gone.
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Warn("runc delete failed", zap.Error(err), zap.ByteString("output", bytes.TrimSpace(out)))
+	}
+	// A container run by this process is only stopped once its bundle is cleaned up.
+	b.mu.Lock()
+	done, ok := b.done[pluginID]
+	b.mu.Unlock()
+	if ok {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			log.Warn("runc run did not return after delete")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	return nil
 }
 
 func (b *Backend) Delete(ctx context.Context, pluginID string) error {
-	_ = b.Stop(ctx, pluginID)
+	log := b.pluginLog(pluginID)
+	if err := b.Stop(ctx, pluginID); err != nil {
+		log.Warn("stop before delete failed", zap.Error(err))
+	}
 	meta, err := b.state.LoadMeta(pluginID)
 	if err == nil && meta.WorkDir != "" {
-		_ = os.RemoveAll(meta.WorkDir)
+		if err := unmountSecrets(meta.WorkDir); err != nil {
+			log.Warn("drop secrets failed", zap.Error(err))
+		}
+		if err := unmountConfig(meta.WorkDir); err != nil {
+			log.Warn("drop config with env secrets failed", zap.Error(err))
+		}
+		// Never remove through the overlay: that would only whiteout, or worse, reach a shared layer.
+		if err := unmountRootfs(meta.WorkDir); err != nil {
+			log.Error("unmount rootfs failed, keeping bundle", zap.String("work_dir", meta.WorkDir), zap.Error(err))
+		} else if err := os.RemoveAll(meta.WorkDir); err != nil {
+			log.Warn("remove bundle failed", zap.String("work_dir", meta.WorkDir), zap.Error(err))
+		}
 	}
 	return b.state.Remove(pluginID)
 }
@@ -173,27 +318,24 @@ func (b *Backend) List(ctx context.Context) ([]backend.InstanceInfo, error) {
 		if meta.Backend != backend.BackendRunc {
 			continue
 		}
-		rs, err := b.getRuncState(id)
-		if err != nil {
-			out = append(out, backend.InstanceInfo{
-				PluginID: id,
-				Backend:  backend.BackendRunc,
-				Status:   "stopped",
-				WorkDir:  meta.WorkDir,
-			})
-			continue
+		info := backend.InstanceInfo{
+			PluginID:      id,
+			Backend:       backend.BackendRunc,
+			Status:        "stopped",
+			WorkDir:       meta.WorkDir,
+			PluginVersion: meta.PluginVersion,
+			DeviceID:      meta.DeviceId,
+			HostType:      meta.HostType,
+			Labels:        meta.Labels,
 		}
-		status := strings.ToLower(rs.Status)
-		if status == "" {
-			status = "running"
+		if rs, err := b.getRuncState(id); err == nil {
+			info.Status = strings.ToLower(rs.Status)
+			if info.Status == "" {
+				info.Status = "running"
+			}
+			info.Pid = rs.Pid
 		}
-		out = append(out, backend.InstanceInfo{
-			PluginID: id,
-			Backend:  backend.BackendRunc,
-			Status:   status,
-			Pid:      rs.Pid,
-			WorkDir:  meta.WorkDir,
-		})
+		out = append(out, info)
 	}
 	return out, nil
 }
@@ -229,8 +371,27 @@ func (b *Backend) State(ctx context.Context, pluginID string) (*backend.StateInf
 }
 
 func (b *Backend) Log(ctx context.Context, pluginID string, opts backend.LogOptions) (io.Reader, error) {
-	p := b.logPath(pluginID)
-	return os.Open(p)
+	meta, err := b.state.LoadMeta(pluginID)
+	if err != nil {
+		return nil, err
+	}
+	exited := func() bool {
+		rs, err := b.getRuncState(pluginID)
+		return err != nil || rs.Status == "" || strings.ToLower(rs.Status) == "stopped"
+	}
+	src := logs.Source{
+		Drivers:  meta.LogDrivers,
+		FilePath: b.state.LogPath(pluginID),
+		Socket:   b.state.LogSocket(pluginID),
+		Parser:   meta.LogParser,
+		Meta: logs.Metadata{
+			PluginID:      pluginID,
+			PluginVersion: meta.PluginVersion,
+			DeviceID:      meta.DeviceId,
+			Backend:       backend.BackendRunc,
+		},
+	}
+	return logs.Read(ctx, src, opts, exited)
 }
 
 // RegisterCancel registers the run's cancel for use on stop.
@@ -246,8 +407,26 @@ func (b *Backend) UnregisterCancel(pluginID string) {
 	delete(b.cancels, pluginID)
 }
 
-// Wait blocks until the container exits or ctx is cancelled (used by re-exec'd shim).
+// Wait blocks until the container exits or ctx is cancelled (used by re-exec'd shim). For a
+// container this process runs it returns once the run's cleanup is done; one run by another process
+// is polled with runc state.
 func (b *Backend) Wait(ctx context.Context, pluginID string) error {
+	b.mu.Lock()
+	done, ok := b.done[pluginID]
+	b.mu.Unlock()
+	if ok {
+		select {
+		case <-done:
+			b.mu.Lock()
+			if b.done[pluginID] == done {
+				delete(b.done, pluginID)
+			}
+			b.mu.Unlock()
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 	for {
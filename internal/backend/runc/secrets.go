@@ -0,0 +1,129 @@
+package runc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// File secrets live on a tmpfs in the bundle, bound read-only into the container.
+const (
+	secretsDir          = ".secrets"
+	containerSecretsDir = "/run/secrets"
+	secretsTmpfsSize    = "size=4m"
+)
+
+// configDir is the tmpfs in the bundle holding a config.json that carries env secrets.
+const configDir = ".config"
+
+// secretsMount is the container's view of the bundle's secrets tmpfs.
+func secretsMount(bundle string) ociMount {
+	return ociMount{
+		Destination: containerSecretsDir,
+		Type:        "bind",
+		Source:      filepath.Join(bundle, secretsDir),
+		Options:     []string{"rbind", "ro", "nosuid", "nodev", "noexec"},
+	}
+}
+
+// configUser returns the container's process user as rendered in the bundle's config.json.
+func configUser(bundle string) (uid, gid int, err error) {
+	b, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return 0, 0, err
+	}
+	var spec ociSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return 0, 0, fmt.Errorf("parse config.json: %w", err)
+	}
+	if spec.Process != nil {
+		uid, gid = int(spec.Process.User.UID), int(spec.Process.User.GID)
+	}
+	return uid, gid, nil
+}
+
+// mountSecrets mounts a fresh tmpfs at <bundle>/.secrets and writes the file secrets into it, owned
+// by uid:gid, the container's process user.
+func mountSecrets(bundle string, secrets []backend.Secret, uid, gid int) error {
+	if err := unmountSecrets(bundle); err != nil {
+		return err
+	}
+	if !backend.HasSecretFiles(secrets) {
+		return nil
+	}
+	target := filepath.Join(bundle, secretsDir)
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("mode=0500,uid=%d,gid=%d,%s", uid, gid, secretsTmpfsSize)
+	if err := syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, data); err != nil {
+		return fmt.Errorf("mount secrets tmpfs: %w", err)
+	}
+	if err := backend.WriteSecretFiles(target, secrets, uid, gid); err != nil {
+		unmountSecrets(bundle)
+		return err
+	}
+	return nil
+}
+
+// unmountSecrets drops the bundle's secrets tmpfs, and with it the values, if mounted.
+func unmountSecrets(bundle string) error {
+	err := syscall.Unmount(filepath.Join(bundle, secretsDir), syscall.MNT_DETACH)
+	if err == nil || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return fmt.Errorf("unmount secrets: %w", err)
+}
+
+// writeConfig writes the rendered config.json into the bundle. One carrying env secrets is written
+// to a private tmpfs at <bundle>/.config and bind-mounted over <bundle>/config.json, so runc reads
+// it as usual but the values never reach the disk; unmountConfig drops it once runc has exited.
+func writeConfig(bundle string, b []byte, secretEnv bool) error {
+	if err := unmountConfig(bundle); err != nil {
+		return err
+	}
+	path := filepath.Join(bundle, "config.json")
+	if !secretEnv {
+		return writePrivate(path, b)
+	}
+	dir := filepath.Join(bundle, configDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0700,"+secretsTmpfsSize); err != nil {
+		return fmt.Errorf("mount config tmpfs: %w", err)
+	}
+	src := filepath.Join(dir, "config.json")
+	// The file on disk is only the mount point.
+	err := writePrivate(src, b)
+	if err == nil {
+		err = writePrivate(path, nil)
+	}
+	if err == nil {
+		if err = syscall.Mount(src, path, "", syscall.MS_BIND, ""); err != nil {
+			err = fmt.Errorf("mount config.json: %w", err)
+		}
+	}
+	if err != nil {
+		unmountConfig(bundle)
+		return err
+	}
+	return nil
+}
+
+// unmountConfig drops a config.json written to the bundle's config tmpfs, and with it the env
+// secrets, leaving the empty file on disk.
+func unmountConfig(bundle string) error {
+	for _, p := range []string{filepath.Join(bundle, "config.json"), filepath.Join(bundle, configDir)} {
+		err := syscall.Unmount(p, syscall.MNT_DETACH)
+		if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("unmount config: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package runc
+
+import (
+	"fmt"
+	goruntime "runtime"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// restrictedUID and restrictedGID are the user the restricted profile runs as (nobody/nogroup) unless
+// the image names a non-root one; a spec override can pick another.
+const (
+	restrictedUID = 65534
+	restrictedGID = 65534
+)
+
+// restrictedTmpSize caps the tmpfs /tmp of the restricted profile's read-only rootfs.
+const restrictedTmpSize = "size=65536k"
+
+// restrictedSyscalls is the restricted profile's seccomp allowlist: what ordinary processes need for
+// files, memory, threads, signals, time and networking. Everything else fails with EPERM, notably
+// mount, namespaces (unshare/setns), ptrace, module and kexec loading, bpf, keyrings, clock and
+// host name changes, reboot, swap and io_uring.
+var restrictedSyscalls = []string{
+	// files and dirs
+	"access", "chdir", "chmod", "chown", "chown32", "close", "close_range", "copy_file_range", "creat",
+	"dup", "dup2", "dup3", "faccessat", "faccessat2", "fadvise64", "fadvise64_64", "fallocate", "fchdir",
+	"fchmod", "fchmodat", "fchmodat2", "fchown", "fchown32", "fchownat", "fcntl", "fcntl64", "fdatasync",
+	"fgetxattr", "flistxattr", "flock", "fremovexattr", "fsetxattr", "fstat", "fstat64", "fstatat64",
+	"fstatfs", "fstatfs64", "fsync", "ftruncate", "ftruncate64", "futimesat", "getcwd", "getdents",
+	"getdents64", "getxattr", "inotify_add_watch", "inotify_init", "inotify_init1", "inotify_rm_watch",
+	"ioctl", "lchown", "lchown32", "lgetxattr", "link", "linkat", "listxattr", "llistxattr", "_llseek",
+	"lremovexattr", "lseek", "lsetxattr", "lstat", "lstat64", "mkdir", "mkdirat", "newfstatat", "open",
+	"openat", "openat2", "pipe", "pipe2", "pread64", "preadv", "preadv2", "pwrite64", "pwritev", "pwritev2",
+	"read", "readahead", "readlink", "readlinkat", "readv", "removexattr", "rename", "renameat",
+	"renameat2", "rmdir", "sendfile", "sendfile64", "setxattr", "splice", "stat", "stat64", "statfs",
+	"statfs64", "statx", "symlink", "symlinkat", "sync", "sync_file_range", "syncfs", "tee", "truncate",
+	"truncate64", "umask", "unlink", "unlinkat", "utime", "utimensat", "utimensat_time64", "utimes",
+	"write", "writev",
+	// polling and events
+	"epoll_create", "epoll_create1", "epoll_ctl", "epoll_pwait", "epoll_pwait2", "epoll_wait", "eventfd",
+	"eventfd2", "io_cancel", "io_destroy", "io_getevents", "io_pgetevents", "io_setup", "io_submit",
+	"poll", "ppoll", "ppoll_time64", "pselect6", "pselect6_time64", "select", "_newselect", "signalfd",
+	"signalfd4", "timerfd_create", "timerfd_gettime", "timerfd_gettime64", "timerfd_settime",
+	"timerfd_settime64",
+	// memory
+	"brk", "madvise", "membarrier", "memfd_create", "mincore", "mlock", "mlock2", "mlockall", "mmap",
+	"mmap2", "mprotect", "mremap", "msync", "munlock", "munlockall", "munmap",
+	// processes, threads and signals
+	"arch_prctl", "capget", "capset", "clone", "clone3", "execve", "execveat", "exit", "exit_group",
+	"fork", "futex", "futex_time64", "futex_waitv", "get_robust_list", "get_thread_area", "getcpu",
+	"getegid", "getegid32", "geteuid", "geteuid32", "getgid", "getgid32", "getgroups", "getgroups32",
+	"getitimer", "getpgid", "getpgrp", "getpid", "getppid", "getpriority", "getrandom", "getresgid",
+	"getresgid32", "getresuid", "getresuid32", "getrlimit", "getrusage", "getsid", "gettid", "getuid",
+	"getuid32", "ioprio_get", "kill", "pause", "prctl", "prlimit64", "restart_syscall", "rseq",
+	"rt_sigaction", "rt_sigpending", "rt_sigprocmask", "rt_sigqueueinfo", "rt_sigreturn",
+	"rt_sigsuspend", "rt_sigtimedwait", "rt_sigtimedwait_time64", "rt_tgsigqueueinfo",
+	"sched_get_priority_max", "sched_get_priority_min", "sched_getaffinity", "sched_getattr",
+	"sched_getparam", "sched_getscheduler", "sched_rr_get_interval", "sched_rr_get_interval_time64",
+	"sched_yield", "set_robust_list", "set_thread_area", "set_tid_address", "setitimer", "setpgid",
+	"setsid", "sigaltstack", "sigreturn", "tgkill", "tkill", "ugetrlimit", "vfork", "wait4", "waitid",
+	"waitpid",
+	// time
+	"alarm", "clock_getres", "clock_getres_time64", "clock_gettime", "clock_gettime64",
+	"clock_nanosleep", "clock_nanosleep_time64", "gettimeofday", "nanosleep", "time", "timer_create",
+	"timer_delete", "timer_getoverrun", "timer_gettime", "timer_gettime64", "timer_settime",
+	"timer_settime64", "times",
+	// networking and IPC
+	"accept", "accept4", "bind", "connect", "getpeername", "getsockname", "getsockopt", "listen",
+	"mq_getsetattr", "mq_notify", "mq_open", "mq_timedreceive", "mq_timedreceive_time64",
+	"mq_timedsend", "mq_timedsend_time64", "mq_unlink", "msgctl", "msgget", "msgrcv", "msgsnd", "recv",
+	"recvfrom", "recvmmsg", "recvmmsg_time64", "recvmsg", "semctl", "semget", "semop", "semtimedop",
+	"semtimedop_time64", "send", "sendmmsg", "sendmsg", "sendto", "setsockopt", "shmat", "shmctl",
+	"shmdt", "shmget", "shutdown", "socket", "socketcall", "socketpair",
+	// system info
+	"sysinfo", "uname",
+}
+
+// seccompArchs are the syscall ABIs the seccomp filter covers on this host.
+var seccompArchs = map[string][]string{
+	"amd64":   {"SCMP_ARCH_X86_64", "SCMP_ARCH_X86", "SCMP_ARCH_X32"},
+	"386":     {"SCMP_ARCH_X86"},
+	"arm64":   {"SCMP_ARCH_AARCH64", "SCMP_ARCH_ARM"},
+	"arm":     {"SCMP_ARCH_ARM"},
+	"riscv64": {"SCMP_ARCH_RISCV64"},
+}
+
+// Paths in /proc and /sys hidden (masked) or made read-only by the restricted profile, as runc's
+// own defaults do.
+var (
+	restrictedMaskedPaths = []string{
+		"/proc/acpi", "/proc/asound", "/proc/interrupts", "/proc/kcore", "/proc/keys",
+		"/proc/latency_stats", "/proc/sched_debug", "/proc/scsi", "/proc/timer_list",
+		"/proc/timer_stats", "/sys/devices/virtual/powercap", "/sys/firmware",
+	}
+	restrictedReadonlyPaths = []string{
+		"/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger",
+	}
+)
+
+// allCapabilities is the privileged profile's capability set.
+var allCapabilities = []string{
+	"CAP_AUDIT_CONTROL", "CAP_AUDIT_READ", "CAP_AUDIT_WRITE", "CAP_BLOCK_SUSPEND", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE", "CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_IPC_LOCK", "CAP_IPC_OWNER", "CAP_KILL", "CAP_LEASE", "CAP_LINUX_IMMUTABLE",
+	"CAP_MAC_ADMIN", "CAP_MAC_OVERRIDE", "CAP_MKNOD", "CAP_NET_ADMIN", "CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST", "CAP_NET_RAW", "CAP_PERFMON", "CAP_SETFCAP", "CAP_SETGID", "CAP_SETPCAP",
+	"CAP_SETUID", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_CHROOT", "CAP_SYS_MODULE", "CAP_SYS_NICE",
+	"CAP_SYS_PACCT", "CAP_SYS_PTRACE", "CAP_SYS_RAWIO", "CAP_SYS_RESOURCE", "CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+}
+
+// applySecurityProfile adjusts the default spec for the plugin's profile (see the backend.Security*
+// constants); default keeps the built-in spec.
+func applySecurityProfile(spec *ociSpec, profile string) error {
+	switch profile {
+	case "", backend.SecurityDefault:
+		return nil
+	case backend.SecurityRestricted:
+		// Never root: a non-root image user stays, a root uid or gid becomes nobody's.
+		if spec.Process.User.UID == 0 {
+			spec.Process.User.UID = restrictedUID
+		}
+		if spec.Process.User.GID == 0 {
+			spec.Process.User.GID = restrictedGID
+		}
+		spec.Process.Capabilities = &ociCapabilities{}
+		spec.Process.NoNewPrivileges = true
+		spec.Root.Readonly = true
+		spec.Linux.Seccomp = &ociSeccomp{
+			DefaultAction: "SCMP_ACT_ERRNO",
+			Architectures: seccompArchs[goruntime.GOARCH],
+			Syscalls:      []ociSyscallRule{{Names: restrictedSyscalls, Action: "SCMP_ACT_ALLOW"}},
+		}
+		spec.Linux.MaskedPaths = restrictedMaskedPaths
+		spec.Linux.ReadonlyPaths = restrictedReadonlyPaths
+		spec.Mounts = append(spec.Mounts, ociMount{
+			Destination: "/tmp",
+			Type:        "tmpfs",
+			Source:      "tmpfs",
+			Options:     []string{"nosuid", "nodev", "noexec", "mode=1777", restrictedTmpSize},
+		})
+		return nil
+	case backend.SecurityPrivileged:
+		caps := func() []string { return append([]string(nil), allCapabilities...) }
+		spec.Process.Capabilities = &ociCapabilities{
+			Bounding:    caps(),
+			Effective:   caps(),
+			Inheritable: caps(),
+			Permitted:   caps(),
+			Ambient:     caps(),
+		}
+		spec.Linux.Seccomp = nil
+		// Every device may be used, though only the --device ones have nodes.
+		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, ociDeviceCgroup{Allow: true, Access: "rwm"})
+		return nil
+	}
+	return fmt.Errorf("unknown security profile %q", profile)
+}
@@ -0,0 +1,147 @@
+package runc
+
+// The types below mirror the OCI runtime spec (config.json) for the fields the runtime generates.
+// Overrides (see override.go) operate on the marshalled JSON, so they can reach any other field.
+
+type ociSpec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *ociProcess       `json:"process,omitempty"`
+	Root        *ociRoot          `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []ociMount        `json:"mounts,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *ociLinux         `json:"linux,omitempty"`
+}
+
+type ociProcess struct {
+	Terminal        bool             `json:"terminal"`
+	User            ociUser          `json:"user"`
+	Args            []string         `json:"args"`
+	Env             []string         `json:"env,omitempty"`
+	Cwd             string           `json:"cwd"`
+	Capabilities    *ociCapabilities `json:"capabilities,omitempty"`
+	Rlimits         []ociRlimit      `json:"rlimits,omitempty"`
+	NoNewPrivileges bool             `json:"noNewPrivileges,omitempty"`
+	Scheduler       *ociScheduler    `json:"scheduler,omitempty"`
+	IOPriority      *ociIOPriority   `json:"ioPriority,omitempty"`
+}
+
+type ociUser struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+type ociCapabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+type ociRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type ociScheduler struct {
+	Policy   string `json:"policy"`
+	Nice     int32  `json:"nice,omitempty"`
+	Priority int32  `json:"priority,omitempty"`
+}
+
+type ociIOPriority struct {
+	Class    string `json:"class"`
+	Priority int    `json:"priority"`
+}
+
+type ociRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+}
+
+type ociMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type ociLinux struct {
+	Resources     *linuxResources   `json:"resources,omitempty"`
+	Devices       []ociDevice       `json:"devices,omitempty"`
+	CgroupsPath   string            `json:"cgroupsPath,omitempty"`
+	Namespaces    []ociNamespace    `json:"namespaces,omitempty"`
+	Sysctl        map[string]string `json:"sysctl,omitempty"`
+	Seccomp       *ociSeccomp       `json:"seccomp,omitempty"`
+	MaskedPaths   []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string          `json:"readonlyPaths,omitempty"`
+}
+
+type ociNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+type ociSeccomp struct {
+	DefaultAction string           `json:"defaultAction"`
+	Architectures []string         `json:"architectures,omitempty"`
+	Syscalls      []ociSyscallRule `json:"syscalls,omitempty"`
+}
+
+type ociSyscallRule struct {
+	Names  []string `json:"names"`
+	Action string   `json:"action"`
+}
+
+// pluginCaps are the capabilities every plugin gets by default.
+var pluginCaps = []string{"CAP_NET_RAW", "CAP_NET_ADMIN"}
+
+// defaultMounts are the mounts of every plugin container: the usual pseudo filesystems and the
+// host's name resolution files. Anything else from the host is a plugin mount (see pluginMounts).
+func defaultMounts() []ociMount {
+	return []ociMount{
+		{Destination: "/proc", Type: "proc", Source: "proc", Options: []string{"nosuid", "noexec", "nodev"}},
+		{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+		{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620", "gid=5"}},
+		{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+		{Destination: "/sys/fs/cgroup", Type: "cgroup", Source: "cgroup", Options: []string{"ro", "nosuid", "noexec", "nodev"}},
+		{Destination: "/etc/hosts", Type: "bind", Source: "/etc/hosts", Options: []string{"rbind", "ro"}},
+		{Destination: "/etc/resolv.conf", Type: "bind", Source: "/etc/resolv.conf", Options: []string{"rbind", "ro"}},
+	}
+}
+
+// defaultSpec is the built-in plugin container spec for the given process.
+func defaultSpec(args, env []string, cwd string, annotations map[string]string, res *linuxResources) *ociSpec {
+	caps := func() []string { return append([]string(nil), pluginCaps...) }
+	return &ociSpec{
+		Version: "1.0.2",
+		Process: &ociProcess{
+			User: ociUser{UID: 0, GID: 0},
+			Args: args,
+			Env:  env,
+			Cwd:  cwd,
+			Capabilities: &ociCapabilities{
+				Bounding:  caps(),
+				Effective: caps(),
+				Permitted: caps(),
+				Ambient:   caps(),
+			},
+		},
+		Root:        &ociRoot{Path: rootfsDir},
+		Annotations: annotations,
+		Mounts:      defaultMounts(),
+		Linux: &ociLinux{
+			Seccomp:   &ociSeccomp{DefaultAction: "SCMP_ACT_ALLOW"},
+			Resources: res,
+			Namespaces: []ociNamespace{
+				{Type: "pid"},
+				{Type: "mount"},
+				{Type: "ipc"},
+				{Type: "uts"},
+			},
+		},
+	}
+}
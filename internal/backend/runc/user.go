@@ -0,0 +1,103 @@
+package runc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tomatopunk/agent-runtime/internal/image"
+)
+
+// imageUser resolves an image config User ("user", "uid", "user:group", "uid:gid" and mixes) the
+// way the container will see it: names are looked up in the rootfs's /etc/passwd and /etc/group,
+// and a user without an explicit group gets its passwd group (0 when a numeric uid has no entry).
+func imageUser(rootfs, spec string) (ociUser, error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	if name == "" || (hasGroup && group == "") {
+		return ociUser{}, fmt.Errorf("invalid image user %q", spec)
+	}
+	var u ociUser
+	uid, numeric := parseID(name)
+	entry, found, err := lookupIDFile(rootfs, "/etc/passwd", func(f []string) bool {
+		if numeric {
+			id, ok := parseID(f[2])
+			return ok && id == uid
+		}
+		return f[0] == name
+	}, 4)
+	if err != nil {
+		return ociUser{}, err
+	}
+	switch {
+	case found:
+		u.UID, _ = parseID(entry[2])
+		u.GID, _ = parseID(entry[3])
+	case numeric:
+		u.UID = uid
+	default:
+		return ociUser{}, fmt.Errorf("image user %q is not in the image's /etc/passwd", name)
+	}
+	if !hasGroup {
+		return u, nil
+	}
+	if gid, ok := parseID(group); ok {
+		u.GID = gid
+		return u, nil
+	}
+	entry, found, err = lookupIDFile(rootfs, "/etc/group", func(f []string) bool { return f[0] == group }, 3)
+	if err != nil {
+		return ociUser{}, err
+	}
+	if !found {
+		return ociUser{}, fmt.Errorf("image group %q is not in the image's /etc/group", group)
+	}
+	u.GID, _ = parseID(entry[2])
+	return u, nil
+}
+
+// parseID parses a numeric uid or gid.
+func parseID(s string) (uint32, bool) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err == nil
+}
+
+// lookupIDFile returns the first line of the colon-separated file name in rootfs (with at least
+// minFields fields and a numeric third one) that match accepts. A missing file matches nothing; a
+// symlink is refused, since opening it would follow it out of the rootfs.
+func lookupIDFile(rootfs, name string, match func([]string) bool, minFields int) ([]string, bool, error) {
+	path, err := image.SafeJoin(rootfs, name)
+	if err != nil {
+		return nil, false, err
+	}
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, false, fmt.Errorf("image %s is not a regular file", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ":")
+		if len(fields) < minFields || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if _, ok := parseID(fields[2]); !ok {
+			continue
+		}
+		if match(fields) {
+			return fields, true, nil
+		}
+	}
+	return nil, false, sc.Err()
+}
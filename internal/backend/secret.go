@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Secret is a value the plugin gets at start without it being persisted: meta.json keeps only the
+// reference, and Source is read again at every start.
+type Secret struct {
+	Name   string `json:"name"`          // file name in the secrets dir, or the env var with Env
+	Source string `json:"source"`        // host file holding the value
+	Env    bool   `json:"env,omitempty"` // deliver as env var Name instead of a file
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseSecret parses NAME=FILE; env picks env delivery. FILE must be absolute.
+func ParseSecret(s string, env bool) (Secret, error) {
+	name, src, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return Secret{}, fmt.Errorf("invalid secret %q: want NAME=FILE", s)
+	}
+	sec := Secret{Name: name, Source: src, Env: env}
+	if err := sec.Validate(); err != nil {
+		return Secret{}, fmt.Errorf("invalid secret %q: %w", s, err)
+	}
+	return sec, nil
+}
+
+// Validate checks the name (a file name, or an env var name with Env) and that Source is absolute.
+func (s Secret) Validate() error {
+	if s.Env {
+		if !envName.MatchString(s.Name) {
+			return fmt.Errorf("%q is not a valid env var name", s.Name)
+		}
+	} else if !volumeName.MatchString(s.Name) {
+		return fmt.Errorf("secret name %q: want letters, digits, '_', '.' and '-', not starting with a symbol", s.Name)
+	}
+	if !filepath.IsAbs(s.Source) {
+		return fmt.Errorf("secret source %q must be an absolute path", s.Source)
+	}
+	return nil
+}
+
+// SecretEnv reads the env-delivered secrets as KEY=VALUE; a trailing newline in the file is dropped.
+func SecretEnv(secrets []Secret) ([]string, error) {
+	var env []string
+	for _, s := range secrets {
+		if !s.Env {
+			continue
+		}
+		b, err := os.ReadFile(s.Source)
+		if err != nil {
+			return nil, fmt.Errorf("read secret %s: %w", s.Name, err)
+		}
+		env = append(env, s.Name+"="+strings.TrimSuffix(string(b), "\n"))
+	}
+	return env, nil
+}
+
+// WriteSecretFiles copies the file-delivered secrets into dir, readable only by uid:gid.
+func WriteSecretFiles(dir string, secrets []Secret, uid, gid int) error {
+	for _, s := range secrets {
+		if s.Env {
+			continue
+		}
+		b, err := os.ReadFile(s.Source)
+		if err != nil {
+			return fmt.Errorf("read secret %s: %w", s.Name, err)
+		}
+		path := filepath.Join(dir, s.Name)
+		if err := os.WriteFile(path, b, 0400); err != nil {
+			return fmt.Errorf("write secret %s: %w", s.Name, err)
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("write secret %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// HasSecretEnv reports whether any secret is delivered as an env var.
+func HasSecretEnv(secrets []Secret) bool {
+	for _, s := range secrets {
+		if s.Env {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSecretFiles reports whether any secret is delivered as a file.
+func HasSecretFiles(secrets []Secret) bool {
+	for _, s := range secrets {
+		if !s.Env {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// File is the runtime-wide config file under the root dir; it is optional.
+const File = "config.json"
+
+// Config holds runtime-wide defaults that apply to every plugin unless overridden per plugin.
+type Config struct {
+	Log       backend.LogRotation `json:"log,omitempty"`
+	LogLimits backend.LogLimits   `json:"log_limits,omitempty"`
+	Verify    VerifyPolicy        `json:"verify,omitempty"`
+	Runc      RuncConfig          `json:"runc,omitempty"`
+	// RuntimeSocket is the host path of the agent's runtime API socket, handed to every plugin as
+	// PLUGIN_RUNTIME_SOCKET (runc plugins get its dir mounted read-only). Unset leaves it out.
+	RuntimeSocket string `json:"runtime_socket,omitempty"`
+}
+
+// RuncConfig holds defaults for the runc backend.
+type RuncConfig struct {
+	// BaseRootfs is a dir (e.g. an unpacked busybox) overlaid read-only under every plugin's rootfs,
+	// unless the plugin sets its own.
+	BaseRootfs string `json:"base_rootfs,omitempty"`
+	// SpecOverride is a file applied to every generated config.json before the plugin's own
+	// patches: a JSON object is a merge patch, a JSON array a JSON patch.
+	SpecOverride string `json:"spec_override,omitempty"`
+	// SecurityProfile is the profile of plugins that do not pick one: default | restricted | privileged.
+	SecurityProfile string `json:"security_profile,omitempty"`
+	// AllowedHostPaths are the host dirs bind mounts may come from: a source must lie at or below one
+	// of them. Without any, plugins get no host bind mounts. This holds for --mount and for binds
+	// added by spec_override, --spec-patch or --spec-file alike.
+	AllowedHostPaths []string `json:"allowed_host_paths,omitempty"`
+}
+
+// VerifyPolicy controls executable verification (see the verify package).
+type VerifyPolicy struct {
+	// RequireSigned refuses to start plugins without a signature from a trusted key.
+	RequireSigned bool `json:"require_signed,omitempty"`
+}
+
+// Load reads <rootDir>/config.json; a missing file yields an empty config.
+func Load(rootDir string) (*Config, error) {
+	path := filepath.Join(rootDir, File)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,230 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+)
+
+// RefPrefix marks a local OCI image layout reference: oci-layout:<dir>[:<tag>].
+const RefPrefix = "oci-layout:"
+
+const defaultTag = "latest"
+
+// Media types read from an OCI image layout.
+const (
+	mediaTypeIndex      = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest   = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeLayer      = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeLayerGzip  = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeDockerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	refNameAnnotation   = "org.opencontainers.image.ref.name"
+)
+
+// Ref is a parsed oci-layout reference.
+type Ref struct {
+	Layout string // dir holding oci-layout, index.json and blobs/
+	Tag    string // matched against the org.opencontainers.image.ref.name annotation
+}
+
+// ParseRef parses oci-layout:<dir>[:<tag>]; the tag defaults to "latest".
+func ParseRef(s string) (Ref, error) {
+	rest, ok := strings.CutPrefix(s, RefPrefix)
+	if !ok || rest == "" {
+		return Ref{}, fmt.Errorf("invalid image %q: want %s<dir>[:<tag>]", s, RefPrefix)
+	}
+	ref := Ref{Layout: rest, Tag: defaultTag}
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i+1:], "/") {
+		ref.Layout, ref.Tag = rest[:i], rest[i+1:]
+	}
+	if ref.Layout == "" || ref.Tag == "" {
+		return Ref{}, fmt.Errorf("invalid image %q: want %s<dir>[:<tag>]", s, RefPrefix)
+	}
+	return ref, nil
+}
+
+// String returns the reference as ParseRef reads it, with the tag spelled out.
+func (r Ref) String() string {
+	return RefPrefix + r.Layout + ":" + r.Tag
+}
+
+// Descriptor is an OCI content descriptor.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform,omitempty"`
+}
+
+type index struct {
+	Manifests []Descriptor `json:"manifests"`
+}
+
+type manifest struct {
+	Config Descriptor   `json:"config"`
+	Layers []Descriptor `json:"layers"`
+}
+
+// Config is the part of the image config the runtime uses as process defaults.
+type Config struct {
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	Env        []string `json:"Env,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+	User       string   `json:"User,omitempty"`
+}
+
+// Image is a resolved image: its manifest digest identifies it (and its unpacked snapshot).
+type Image struct {
+	Ref    Ref
+	Digest string // manifest digest, sha256:<hex>
+	Config Config
+	Layers []Descriptor
+}
+
+// Resolve reads index.json, picks the manifest tagged ref.Tag (descending into a nested index for
+// this platform) and loads the image config. Every blob read is checked against its digest.
+func Resolve(s string) (*Image, error) {
+	ref, err := ParseRef(s)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(ref.Layout, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("read image index: %w", err)
+	}
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, fmt.Errorf("parse image index: %w", err)
+	}
+	desc, err := pickTagged(idx.Manifests, ref.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", s, err)
+	}
+	if desc.MediaType == mediaTypeIndex {
+		b, err := readBlob(ref.Layout, desc)
+		if err != nil {
+			return nil, err
+		}
+		var nested index
+		if err := json.Unmarshal(b, &nested); err != nil {
+			return nil, fmt.Errorf("parse image index %s: %w", desc.Digest, err)
+		}
+		if desc, err = pickPlatform(nested.Manifests); err != nil {
+			return nil, fmt.Errorf("image %s: %w", s, err)
+		}
+	}
+	if desc.MediaType != "" && desc.MediaType != mediaTypeManifest {
+		return nil, fmt.Errorf("image %s: unsupported manifest media type %s", s, desc.MediaType)
+	}
+	b, err = readBlob(ref.Layout, desc)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse image manifest: %w", err)
+	}
+	b, err = readBlob(ref.Layout, m.Config)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Config Config `json:"config"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse image config: %w", err)
+	}
+	return &Image{Ref: ref, Digest: desc.Digest, Config: cfg.Config, Layers: m.Layers}, nil
+}
+
+func pickTagged(ds []Descriptor, tag string) (Descriptor, error) {
+	for _, d := range ds {
+		if d.Annotations[refNameAnnotation] == tag {
+			return d, nil
+		}
+	}
+	// An untagged single-image layout is addressed by the default tag.
+	if len(ds) == 1 && tag == defaultTag && ds[0].Annotations[refNameAnnotation] == "" {
+		return ds[0], nil
+	}
+	return Descriptor{}, fmt.Errorf("tag %q not found", tag)
+}
+
+func pickPlatform(ds []Descriptor) (Descriptor, error) {
+	for _, d := range ds {
+		if d.Platform == nil || (d.Platform.OS == "linux" && d.Platform.Architecture == goruntime.GOARCH) {
+			return d, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no manifest for linux/%s", goruntime.GOARCH)
+}
+
+// blobPath returns the path of a blob in the layout, rejecting malformed digests.
+func blobPath(layout, digest string) (string, error) {
+	hexPart, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hexPart) != sha256.Size*2 {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(layout, "blobs", "sha256", hexPart), nil
+}
+
+// readBlob reads a small blob (index, manifest, config) and checks its digest.
+func readBlob(layout string, d Descriptor) ([]byte, error) {
+	path, err := blobPath(layout, d.Digest)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read blob: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	if got := "sha256:" + hex.EncodeToString(sum[:]); got != d.Digest {
+		return nil, fmt.Errorf("blob %s is corrupt (got %s)", d.Digest, got)
+	}
+	return b, nil
+}
+
+// verifyingReader hashes what is read through it; check compares the result after EOF.
+type verifyingReader struct {
+	r      io.Reader
+	h      hashWriter
+	digest string
+}
+
+type hashWriter interface {
+	io.Writer
+	Sum([]byte) []byte
+}
+
+func newVerifyingReader(r io.Reader, digest string) *verifyingReader {
+	h := sha256.New()
+	return &verifyingReader{r: io.TeeReader(r, h), h: h, digest: digest}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) { return v.r.Read(p) }
+
+func (v *verifyingReader) check() error {
+	// Drain trailing bytes the tar reader did not need (padding), so the hash covers the whole blob.
+	if _, err := io.Copy(io.Discard, v.r); err != nil {
+		return err
+	}
+	if got := "sha256:" + hex.EncodeToString(v.h.Sum(nil)); got != v.digest {
+		return fmt.Errorf("layer %s is corrupt (got %s)", v.digest, got)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Whiteout markers from the OCI layer spec.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// maxSymlinks bounds symlink resolution in SafeJoin.
+const maxSymlinks = 40
+
+// Unpack returns the rootfs of img's snapshot under snapshotsDir, unpacking the layers first if no
+// plugin has used the image yet. Snapshots are keyed by manifest digest, so every plugin running
+// the same image shares one; they must be treated as read-only (the runc backend overlays them).
+func Unpack(img *Image, snapshotsDir string) (string, error) {
+	key := strings.TrimPrefix(img.Digest, "sha256:")
+	final := filepath.Join(snapshotsDir, key)
+	rootfs := filepath.Join(final, "rootfs")
+	if _, err := os.Stat(rootfs); err == nil {
+		return rootfs, nil
+	}
+	if err := os.MkdirAll(snapshotsDir, 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(snapshotsDir, ".unpack-"+key[:12]+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Mkdir(filepath.Join(tmp, "rootfs"), 0755); err != nil {
+		return "", err
+	}
+	for _, l := range img.Layers {
+		if err := applyLayer(img.Ref.Layout, l, filepath.Join(tmp, "rootfs")); err != nil {
+			return "", fmt.Errorf("unpack layer %s: %w", l.Digest, err)
+		}
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		// Another plugin unpacked the same image concurrently; use its snapshot.
+		if _, serr := os.Stat(rootfs); serr == nil {
+			return rootfs, nil
+		}
+		return "", err
+	}
+	return rootfs, nil
+}
+
+// applyLayer extracts one layer blob onto root, honouring whiteouts, and checks the blob digest.
+func applyLayer(layout string, d Descriptor, root string) error {
+	path, err := blobPath(layout, d.Digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	vr := newVerifyingReader(f, d.Digest)
+	var r io.Reader = vr
+	switch d.MediaType {
+	case mediaTypeLayer:
+	case mediaTypeLayerGzip, mediaTypeDockerGzip:
+		gz, err := gzip.NewReader(vr)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return fmt.Errorf("unsupported layer media type %s", d.MediaType)
+	}
+	// Paths written by this layer; an opaque whiteout only hides what lower layers put there.
+	created := map[string]bool{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := applyEntry(root, hdr, tr, created); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+	return vr.check()
+}
+
+func applyEntry(root string, hdr *tar.Header, r io.Reader, created map[string]bool) error {
+	dir, base := filepath.Split(filepath.Clean("/" + hdr.Name))
+	if base == whiteoutOpaque {
+		parent, err := SafeJoin(root, dir)
+		if err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, e := range entries {
+			p := filepath.Join(parent, e.Name())
+			if !created[p] {
+				if err := os.RemoveAll(p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if name, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+		p, err := SafeJoin(root, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(p)
+	}
+	path, err := SafeJoin(root, hdr.Name)
+	if err != nil {
+		return err
+	}
+	if path == root {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Replace whatever a lower layer had here, except that a directory stays a directory.
+	if fi, err := os.Lstat(path); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	mode := hdr.FileInfo().Mode()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := SafeJoin(root, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		devType := map[byte]uint32{tar.TypeChar: syscall.S_IFCHR, tar.TypeBlock: syscall.S_IFBLK, tar.TypeFifo: syscall.S_IFIFO}[hdr.Typeflag]
+		dev := (hdr.Devmajor << 8) | (hdr.Devminor & 0xff) | ((hdr.Devminor &^ 0xff) << 12)
+		if err := syscall.Mknod(path, devType|uint32(mode.Perm()), int(dev)); err != nil {
+			if errors.Is(err, syscall.EPERM) {
+				// Unprivileged unpack: device nodes are normally provided by the runtime's /dev anyway.
+				return nil
+			}
+			return err
+		}
+	default:
+		return nil
+	}
+	created[path] = true
+	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil && !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// After chown, which clears setuid/setgid.
+	return os.Chmod(path, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
+
+// SafeJoin resolves name under root the way the container will see it: symlinks in the tree are
+// followed, but scoped to root (an absolute target or ".." never leaves it). The final component is
+// not resolved, so a symlink there can be replaced rather than written through.
+func SafeJoin(root, name string) (string, error) {
+	clean := filepath.Clean("/" + name)
+	if clean == "/" {
+		return root, nil
+	}
+	dir, base := filepath.Split(clean)
+	rest := splitPath(dir)
+	cur := root
+	hops := 0
+	for len(rest) > 0 {
+		p := rest[0]
+		rest = rest[1:]
+		if p == ".." {
+			if cur != root {
+				cur = filepath.Dir(cur)
+			}
+			continue
+		}
+		next := filepath.Join(cur, p)
+		fi, err := os.Lstat(next)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			// Not created yet: MkdirAll will make it a plain dir.
+			cur = next
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+		if hops++; hops > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks resolving %q", name)
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			cur = root
+		}
+		rest = append(splitPath(link), rest...)
+	}
+	return filepath.Join(cur, base), nil
+}
+
+func splitPath(p string) []string {
+	var out []string
+	for _, c := range strings.Split(p, "/") {
+		if c != "" && c != "." {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,229 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Key and value syntax follows Kubernetes: an optional DNS prefix ("example.com/"), then up to 63
+// alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric. Values may be empty.
+var (
+	nameRe   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	prefixRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+)
+
+// ValidateKey reports whether k is a valid label key.
+func ValidateKey(k string) error {
+	name := k
+	if prefix, rest, ok := strings.Cut(k, "/"); ok {
+		if !prefixRe.MatchString(prefix) {
+			return fmt.Errorf("invalid label key %q: bad prefix", k)
+		}
+		name = rest
+	}
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid label key %q", k)
+	}
+	return nil
+}
+
+// ValidateValue reports whether v is a valid label value.
+func ValidateValue(v string) error {
+	if v != "" && !nameRe.MatchString(v) {
+		return fmt.Errorf("invalid label value %q", v)
+	}
+	return nil
+}
+
+// Parse parses comma-separated KEY=VALUE pairs (the --label syntax).
+func Parse(s string) (map[string]string, error) {
+	out := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return out, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: want KEY=VALUE", kv)
+		}
+		if err := ValidateKey(k); err != nil {
+			return nil, err
+		}
+		if err := ValidateValue(v); err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// Format renders labels as sorted, comma-separated KEY=VALUE pairs.
+func Format(l map[string]string) string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + l[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+// Operators supported in selectors.
+const (
+	opEquals       = "="
+	opNotEquals    = "!="
+	opIn           = "in"
+	opNotIn        = "notin"
+	opExists       = "exists"
+	opDoesNotExist = "!"
+)
+
+// requirement is one comma-separated term of a selector.
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+func (r requirement) matches(l map[string]string) bool {
+	v, ok := l[r.key]
+	switch r.op {
+	case opExists:
+		return ok
+	case opDoesNotExist:
+		return !ok
+	case opEquals:
+		return ok && v == r.values[0]
+	case opNotEquals:
+		return !ok || v != r.values[0]
+	case opIn:
+		return ok && contains(r.values, v)
+	case opNotIn:
+		return !ok || !contains(r.values, v)
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Selector is a parsed label selector; all requirements must match. The zero value matches everything.
+type Selector struct {
+	reqs []requirement
+}
+
+// Empty reports whether the selector has no requirements.
+func (s Selector) Empty() bool { return len(s.reqs) == 0 }
+
+// Matches reports whether l satisfies every requirement.
+func (s Selector) Matches(l map[string]string) bool {
+	for _, r := range s.reqs {
+		if !r.matches(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses a Kubernetes-style selector: comma-separated terms of the form
+// key=value, key==value, key!=value, key in (a,b), key notin (a,b), key or !key.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	terms, err := splitTerms(s)
+	if err != nil {
+		return sel, err
+	}
+	for _, t := range terms {
+		r, err := parseRequirement(t)
+		if err != nil {
+			return Selector{}, err
+		}
+		sel.reqs = append(sel.reqs, r)
+	}
+	return sel, nil
+}
+
+// splitTerms splits on commas outside parentheses.
+func splitTerms(s string) ([]string, error) {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("invalid selector %q: unbalanced parentheses", s)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid selector %q: unbalanced parentheses", s)
+	}
+	terms = append(terms, s[start:])
+	out := terms[:0]
+	for _, t := range terms {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+var setRe = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+func parseRequirement(t string) (requirement, error) {
+	if m := setRe.FindStringSubmatch(t); m != nil {
+		r := requirement{key: m[1], op: m[2]}
+		for _, v := range strings.Split(m[3], ",") {
+			v = strings.TrimSpace(v)
+			if err := ValidateValue(v); err != nil {
+				return r, err
+			}
+			r.values = append(r.values, v)
+		}
+		return r, ValidateKey(r.key)
+	}
+	var r requirement
+	switch {
+	case strings.Contains(t, "!="):
+		k, v, _ := strings.Cut(t, "!=")
+		r = requirement{key: strings.TrimSpace(k), op: opNotEquals, values: []string{strings.TrimSpace(v)}}
+	case strings.Contains(t, "=="):
+		k, v, _ := strings.Cut(t, "==")
+		r = requirement{key: strings.TrimSpace(k), op: opEquals, values: []string{strings.TrimSpace(v)}}
+	case strings.Contains(t, "="):
+		k, v, _ := strings.Cut(t, "=")
+		r = requirement{key: strings.TrimSpace(k), op: opEquals, values: []string{strings.TrimSpace(v)}}
+	case strings.HasPrefix(t, "!"):
+		r = requirement{key: strings.TrimSpace(t[1:]), op: opDoesNotExist}
+	default:
+		r = requirement{key: t, op: opExists}
+	}
+	if err := ValidateKey(r.key); err != nil {
+		return r, fmt.Errorf("invalid selector term %q: %w", t, err)
+	}
+	for _, v := range r.values {
+		if err := ValidateValue(v); err != nil {
+			return r, fmt.Errorf("invalid selector term %q: %w", t, err)
+		}
+	}
+	return r, nil
+}
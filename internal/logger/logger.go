@@ -1,23 +1,75 @@
 package logger
 
 import (
+	"fmt"
+	"os"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Fields every shim and backend line about a plugin carries (see ForPlugin).
+const (
+	FieldPluginID   = "plugin_id"
+	FieldBackend    = "backend"
+	FieldRuntimePid = "runtime_pid"
+)
+
+// Options configure the runtime's own operational log (not plugin output).
+type Options struct {
+	Level    string // debug | info | warn | error (default info)
+	Encoding string // json | console (default json)
+	// Output receives the log; nil is stderr. It must be safe for concurrent use.
+	Output zapcore.WriteSyncer
+}
+
 // New returns a zap logger (JSON encoder to stderr, info level).
 func New() *zap.Logger {
-	cfg := zap.NewProductionConfig()
-	cfg.OutputPaths = []string{"stderr"}
-	cfg.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
-	log, err := cfg.Build()
+	log, err := Build(Options{})
 	if err != nil {
 		panic(err)
 	}
 	return log
 }
 
+// Build returns a zap logger configured by opts.
+func Build(opts Options) (*zap.Logger, error) {
+	level := zapcore.InfoLevel
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", opts.Level, err)
+		}
+	}
+	encCfg := zap.NewProductionEncoderConfig()
+	var enc zapcore.Encoder
+	switch opts.Encoding {
+	case "", "json":
+		enc = zapcore.NewJSONEncoder(encCfg)
+	case "console":
+		encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		encCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		enc = zapcore.NewConsoleEncoder(encCfg)
+	default:
+		return nil, fmt.Errorf("invalid log encoding %q (want json | console)", opts.Encoding)
+	}
+	out := opts.Output
+	if out == nil {
+		out = zapcore.Lock(os.Stderr)
+	}
+	core := zapcore.NewCore(enc, out, zap.NewAtomicLevelAt(level))
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), nil
+}
+
 // NewNop returns a no-op logger.
 func NewNop() *zap.Logger {
 	return zap.NewNop()
 }
+
+// ForPlugin returns log with the fields every shim and backend line carries.
+func ForPlugin(log *zap.Logger, pluginID, backendName string) *zap.Logger {
+	return log.With(
+		zap.String(FieldPluginID, pluginID),
+		zap.String(FieldBackend, backendName),
+		zap.Int(FieldRuntimePid, os.Getpid()),
+	)
+}
@@ -0,0 +1,134 @@
+package logs
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Plugin output is stored and served one line per record in the CRI log format:
+//
+//	<RFC3339Nano timestamp> <stream> <tag> <message>
+//
+// where stream is "stdout" or "stderr" and tag is "F" for a full line or "P" for a partial
+// line that continues in the next record of the same stream.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+
+	tagFull    = "F"
+	tagPartial = "P"
+
+	// maxRecordSize splits longer lines into P records so a single line cannot grow without bound.
+	maxRecordSize = 16 * 1024
+)
+
+// Record is one line (or partial line) of plugin output as handed to the log drivers.
+type Record struct {
+	Timestamp time.Time
+	Stream    string
+	Partial   bool   // the line continues in the next record of the same stream
+	Line      []byte // without the trailing newline
+}
+
+// AppendCRI appends the CRI encoding of rec, including the trailing newline, to b.
+func AppendCRI(b []byte, rec Record) []byte {
+	b = rec.Timestamp.UTC().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, ' ')
+	b = append(b, rec.Stream...)
+	b = append(b, ' ')
+	if rec.Partial {
+		b = append(b, tagPartial...)
+	} else {
+		b = append(b, tagFull...)
+	}
+	b = append(b, ' ')
+	b = append(b, rec.Line...)
+	return append(b, '\n')
+}
+
+// truncatedMarker is appended to lines cut at the max line size.
+const truncatedMarker = " [truncated]"
+
+// StreamWriter splits the raw output of one stream into records and hands them to a driver.
+// Records are delivered one at a time, so stdout and stderr writers can share a driver.
+type StreamWriter struct {
+	drv     Driver
+	stream  string
+	maxLine int // 0 = no cap (long lines are still split into P records)
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buf     []byte
+	sent    int  // bytes of the current line already emitted as P records
+	discard bool // dropping the rest of a line that hit maxLine
+}
+
+// NewStreamWriter returns a writer that tags its output with stream and truncates lines longer than maxLine.
+func NewStreamWriter(drv Driver, stream string, maxLine int) *StreamWriter {
+	return &StreamWriter{drv: drv, stream: stream, maxLine: maxLine, now: time.Now}
+}
+
+// Write emits a record for every complete line in p and buffers the rest.
+// It never fails: a driver error drops the record rather than closing the plugin's pipe
+// (which would kill the plugin with SIGPIPE on its next write).
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		switch {
+		case w.discard:
+			w.discard = i < 0
+		default:
+			w.buf = append(w.buf, chunk...)
+			if w.maxLine > 0 && w.sent+len(w.buf) > w.maxLine {
+				keep := w.maxLine - w.sent
+				w.emit(false, append(w.buf[:keep:keep], truncatedMarker...))
+				w.buf, w.sent = w.buf[:0], 0
+				w.discard = i < 0
+			} else if i >= 0 {
+				w.emit(false, w.buf)
+				w.buf, w.sent = w.buf[:0], 0
+			} else {
+				for len(w.buf) >= maxRecordSize {
+					w.emit(true, w.buf[:maxRecordSize])
+					w.sent += maxRecordSize
+					w.buf = w.buf[maxRecordSize:]
+				}
+			}
+		}
+		if i < 0 {
+			break
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Close flushes an unterminated last line as a full record.
+func (w *StreamWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.emit(false, w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *StreamWriter) emit(partial bool, line []byte) error {
+	// Drivers may keep the record (ring), so hand over a copy rather than our buffer.
+	return w.drv.Log(Record{
+		Timestamp: w.now(),
+		Stream:    w.stream,
+		Partial:   partial,
+		Line:      append([]byte(nil), line...),
+	})
+}
@@ -0,0 +1,120 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// Log driver names accepted by --log-driver.
+const (
+	DriverFile     = "file"
+	DriverSyslog   = "syslog"
+	DriverJournald = "journald"
+	DriverRing     = "ring"
+)
+
+// Driver receives plugin log records from the shim.
+type Driver interface {
+	Log(rec Record) error
+	Close() error
+}
+
+// DriverConfig is what the shim knows about a plugin when it sets up its log drivers.
+type DriverConfig struct {
+	Names    []string          // drivers to fan out to; empty means file only
+	Options  map[string]string // driver options (--log-opt), keys prefixed by driver name
+	PluginID string
+	DeviceID string
+	FilePath string // live log file for the file driver
+	Socket   string // unix socket the ring driver serves on
+	Rotation backend.LogRotation
+	Limits   backend.LogLimits
+	// OnDropped is called with the running total whenever rate-limited lines are reported.
+	OnDropped func(total int64)
+	// OnError is called with failures a driver gets past on its own, such as a failed rotation.
+	OnError func(error)
+}
+
+// NewDriver builds the configured drivers; with more than one, records fan out to all of them.
+func NewDriver(cfg DriverConfig) (Driver, error) {
+	names := cfg.Names
+	if len(names) == 0 {
+		names = []string{DriverFile}
+	}
+	var drivers multiDriver
+	for _, name := range names {
+		d, err := newDriver(name, cfg)
+		if err != nil {
+			drivers.Close()
+			return nil, fmt.Errorf("log driver %s: %w", name, err)
+		}
+		drivers = append(drivers, d)
+	}
+	if len(drivers) == 1 {
+		return drivers[0], nil
+	}
+	return drivers, nil
+}
+
+func newDriver(name string, cfg DriverConfig) (Driver, error) {
+	switch name {
+	case DriverFile:
+		return newFileDriver(cfg.FilePath, cfg.Rotation, cfg.OnError)
+	case DriverSyslog:
+		return newSyslogDriver(cfg.Options["syslog-address"], cfg.PluginID)
+	case DriverJournald:
+		return newJournaldDriver(cfg.Options["journald-socket"], cfg.PluginID, cfg.DeviceID)
+	case DriverRing:
+		return newRingDriver(cfg.Socket, cfg.Options["ring-size"])
+	default:
+		return nil, fmt.Errorf("unknown driver (want %s|%s|%s|%s)", DriverFile, DriverSyslog, DriverJournald, DriverRing)
+	}
+}
+
+// multiDriver fans records out to several drivers; one failing driver does not starve the others.
+type multiDriver []Driver
+
+func (m multiDriver) Log(rec Record) error {
+	var errs []error
+	for _, d := range m {
+		if err := d.Log(rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiDriver) Close() error {
+	var errs []error
+	for _, d := range m {
+		if err := d.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fileDriver writes CRI records to a size-rotated file; it is the only driver `log` reads from disk.
+type fileDriver struct {
+	w *RotatingWriter
+}
+
+func newFileDriver(path string, rotation backend.LogRotation, onError func(error)) (*fileDriver, error) {
+	w, err := NewRotatingWriter(path, rotation)
+	if err != nil {
+		return nil, err
+	}
+	w.OnError = onError
+	return &fileDriver{w: w}, nil
+}
+
+func (d *fileDriver) Log(rec Record) error {
+	_, err := d.w.Write(AppendCRI(make([]byte, 0, len(rec.Line)+64), rec))
+	return err
+}
+
+func (d *fileDriver) Close() error {
+	return d.w.Close()
+}
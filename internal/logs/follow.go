@@ -0,0 +1,130 @@
+package logs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// pollInterval is the fallback wake-up when inotify is unavailable; it also paces the exit check.
+const pollInterval = time.Second
+
+// follow streams lines appended to path after the current offset of f until ctx is cancelled.
+// It reopens path when the file is rotated (new inode) and rewinds when it is truncated.
+// If exited is non-nil, follow returns once it reports true and the remaining output is drained.
+func follow(ctx context.Context, path string, f *os.File, r *renderer, exited func() bool) error {
+	defer func() { f.Close() }()
+	var events <-chan struct{}
+	if w := newWatcher(filepath.Dir(path)); w != nil {
+		defer w.Close()
+		events = w.events
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.copy(f, false); err != nil {
+			return err
+		}
+		if err := r.w.Flush(); err != nil {
+			return err
+		}
+		next, err := reopenIfRotated(path, f)
+		if err != nil {
+			return err
+		}
+		if next != nil {
+			// Drain what the old file still holds before switching.
+			err := r.copy(f, true)
+			f.Close()
+			f = next
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if exited != nil && exited() {
+			if err := r.copy(f, true); err != nil {
+				return err
+			}
+			return r.w.Flush()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
+
+// reopenIfRotated returns a newly opened file if path now refers to a different file than f,
+// and rewinds f if it was truncated in place. It returns nil if f is still current.
+func reopenIfRotated(path string, f *os.File) (*os.File, error) {
+	cur, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	onDisk, err := os.Stat(path)
+	if err != nil {
+		// Rotated away and not recreated yet; keep the old file until the new one appears.
+		return nil, nil
+	}
+	if !os.SameFile(cur, onDisk) {
+		next, err := os.Open(path)
+		if err != nil {
+			return nil, nil
+		}
+		return next, nil
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if cur.Size() < off {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	return nil, err
+}
+
+// watcher wakes follow when anything in the log dir changes (writes, creates, renames).
+type watcher struct {
+	f      *os.File
+	events chan struct{}
+}
+
+// newWatcher returns nil if inotify is not available; follow then falls back to polling.
+func newWatcher(dir string) *watcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil
+	}
+	mask := uint32(syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil
+	}
+	// A non-blocking fd wrapped by os.NewFile uses the runtime poller, so Close unblocks Read.
+	w := &watcher{f: os.NewFile(uintptr(fd), "inotify"), events: make(chan struct{}, 1)}
+	go w.loop()
+	return w
+}
+
+func (w *watcher) loop() {
+	buf := make([]byte, 4096)
+	for {
+		if _, err := w.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *watcher) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,73 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultJournaldSocket is where systemd-journald accepts its native protocol.
+const defaultJournaldSocket = "/run/systemd/journal/socket"
+
+// journaldDriver speaks the journald native protocol: one datagram per record made of
+// FIELD=value lines (binary-safe length-prefixed form for values containing newlines).
+type journaldDriver struct {
+	conn     *net.UnixConn
+	pluginID string
+	deviceID string
+}
+
+func newJournaldDriver(socket, pluginID, deviceID string) (*journaldDriver, error) {
+	if socket == "" {
+		socket = defaultJournaldSocket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldDriver{conn: conn, pluginID: pluginID, deviceID: deviceID}, nil
+}
+
+func (d *journaldDriver) Log(rec Record) error {
+	// syslog priorities: 6 = info, 3 = err
+	priority := 6
+	if rec.Stream == StreamStderr {
+		priority = 3
+	}
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", string(rec.Line))
+	journalField(&b, "PRIORITY", strconv.Itoa(priority))
+	journalField(&b, "SYSLOG_IDENTIFIER", d.pluginID)
+	journalField(&b, "PLUGIN_ID", d.pluginID)
+	journalField(&b, "PLUGIN_STREAM", rec.Stream)
+	if d.deviceID != "" {
+		journalField(&b, "DEVICE_ID", d.deviceID)
+	}
+	if rec.Partial {
+		journalField(&b, "PLUGIN_PARTIAL", "1")
+	}
+	_, err := d.conn.Write(b.Bytes())
+	return err
+}
+
+func (d *journaldDriver) Close() error {
+	return d.conn.Close()
+}
+
+// journalField appends one field in the native protocol encoding.
+func journalField(b *bytes.Buffer, name, value string) {
+	if !strings.ContainsRune(value, '\n') {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,136 @@
+package logs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// dropWindow is how often the limiter reports dropped lines.
+const dropWindow = 10 * time.Second
+
+// bucket is a token bucket refilled at rate tokens per second up to burst.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket. An unset burst is one second's worth; the burst is never below
+// least, the cost of the largest single record, which could otherwise never pass.
+func newBucket(rate, burst, least float64) *bucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	burst = max(burst, least)
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// limiter drops records beyond the line and byte rates, and every dropWindow writes a
+// "dropped N lines" summary record to the wrapped driver.
+type limiter struct {
+	drv       Driver
+	lines     *bucket // nil = unlimited
+	bytes     *bucket // nil = unlimited
+	onDropped func(total int64)
+
+	mu      sync.Mutex
+	dropped int64 // in the current window
+	total   int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newLimiter(drv Driver, lines, bytes *bucket, onDropped func(int64)) *limiter {
+	l := &limiter{
+		drv:       drv,
+		lines:     lines,
+		bytes:     bytes,
+		onDropped: onDropped,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go l.report()
+	return l
+}
+
+func (l *limiter) Log(rec Record) error {
+	l.mu.Lock()
+	now := time.Now()
+	allowed := true
+	if l.lines != nil {
+		l.lines.refill(now)
+		allowed = l.lines.tokens >= 1
+	}
+	if allowed && l.bytes != nil {
+		l.bytes.refill(now)
+		allowed = l.bytes.tokens >= float64(len(rec.Line))
+	}
+	if !allowed {
+		// Count whole lines only; the P records of a long line would otherwise inflate the number.
+		if !rec.Partial {
+			l.dropped++
+		}
+		l.mu.Unlock()
+		return nil
+	}
+	if l.lines != nil {
+		l.lines.tokens--
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(len(rec.Line))
+	}
+	l.mu.Unlock()
+	return l.drv.Log(rec)
+}
+
+func (l *limiter) report() {
+	defer close(l.done)
+	t := time.NewTicker(dropWindow)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			l.flush()
+		case <-l.stop:
+			l.flush()
+			return
+		}
+	}
+}
+
+// flush writes the summary for the current window, if anything was dropped.
+func (l *limiter) flush() {
+	l.mu.Lock()
+	n := l.dropped
+	l.dropped = 0
+	l.total += n
+	total := l.total
+	l.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	_ = l.drv.Log(Record{
+		Timestamp: time.Now(),
+		Stream:    StreamStderr,
+		Line:      []byte(fmt.Sprintf("[agent-runtime] dropped %d lines in last %s", n, dropWindow)),
+	})
+	if l.onDropped != nil {
+		l.onDropped(total)
+	}
+}
+
+func (l *limiter) Close() error {
+	close(l.stop)
+	<-l.done
+	return l.drv.Close()
+}
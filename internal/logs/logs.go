@@ -0,0 +1,405 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// Entry is one plugin log line in the unified format.
+type Entry struct {
+	Timestamp time.Time      `json:"timestamp,omitzero"` // when the shim captured the line
+	Stream    string         `json:"stream"`
+	Level     string         `json:"level,omitempty"`
+	Message   string         `json:"message"`
+	LoggedAt  time.Time      `json:"logged_at,omitzero"` // timestamp the plugin wrote into a structured line
+	Fields    map[string]any `json:"-"`                  // parsed fields; --format json merges them into the object
+}
+
+// Metadata is the runtime's view of the plugin, added to every line in --format json.
+type Metadata struct {
+	PluginID      string `json:"plugin_id,omitempty"`
+	PluginVersion string `json:"plugin_version,omitempty"`
+	DeviceID      string `json:"device_id,omitempty"`
+	Backend       string `json:"backend,omitempty"`
+}
+
+// ParseLine splits a stored log line into an Entry and returns its CRI tag ("F" or "P").
+// Lines written before the CRI format (raw plugin output) yield an empty tag: a leading RFC3339
+// timestamp, as printed by most loggers, is used if present and the stream is reported as stdout.
+func ParseLine(line string) (Entry, string) {
+	if e, tag, ok := parseCRI(line); ok {
+		return e, tag
+	}
+	e := Entry{Stream: StreamStdout, Message: line}
+	first, rest, _ := strings.Cut(line, " ")
+	if t, err := time.Parse(time.RFC3339Nano, first); err == nil {
+		e.Timestamp = t
+		e.Message = rest
+	}
+	return e, ""
+}
+
+// parseCRI parses "<timestamp> <stream> <tag> <message>".
+func parseCRI(line string) (Entry, string, bool) {
+	ts, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return Entry{}, "", false
+	}
+	stream, rest, ok := strings.Cut(rest, " ")
+	if !ok || (stream != StreamStdout && stream != StreamStderr) {
+		return Entry{}, "", false
+	}
+	tag, msg, ok := strings.Cut(rest, " ")
+	if !ok {
+		// An empty full line is written as "<ts> <stream> F " but tolerate a missing trailing space.
+		tag, msg = rest, ""
+	}
+	if tag != tagFull && tag != tagPartial {
+		return Entry{}, "", false
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return Entry{}, "", false
+	}
+	return Entry{Timestamp: t, Stream: stream, Message: msg}, tag, true
+}
+
+// errPastEnd stops reading once a line is newer than opts.End.
+var errPastEnd = errors.New("past end of requested range")
+
+// Source tells Read where a plugin's log can be read back from.
+type Source struct {
+	Drivers  []string // configured drivers; empty means file
+	FilePath string   // live file of the file driver
+	Socket   string   // socket of the ring driver
+	Parser   string   // --log-parser given at run time
+	Meta     Metadata
+}
+
+// Read returns the plugin log from the first readable driver: the file if configured, else the
+// shim's in-memory ring. syslog and journald are write-only from the runtime's point of view.
+func Read(ctx context.Context, src Source, opts backend.LogOptions, exited func() bool) (io.ReadCloser, error) {
+	drivers := src.Drivers
+	if len(drivers) == 0 {
+		drivers = []string{DriverFile}
+	}
+	r, err := newRenderer(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(drivers, DriverFile) {
+		return openFile(ctx, src.FilePath, r, exited)
+	}
+	if slices.Contains(drivers, DriverRing) {
+		return openRing(ctx, src.Socket, r)
+	}
+	return nil, fmt.Errorf("log drivers %s cannot be read back; use the system log tools", strings.Join(drivers, ","))
+}
+
+// openRing reads the records buffered by the shim's ring driver.
+func openRing(ctx context.Context, socket string, r *renderer) (io.ReadCloser, error) {
+	opts := r.opts
+	tail := 0
+	if !r.fullScan() {
+		tail = opts.Length
+	}
+	conn, err := dialRing(ctx, socket, opts.Follow, tail)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	r.w = bufio.NewWriter(pw)
+	go func() {
+		defer conn.Close()
+		err := r.copy(conn, true)
+		if ctx.Err() != nil {
+			// Follow ended by the caller closing the connection.
+			err = nil
+		}
+		if err == nil || errors.Is(err, errPastEnd) {
+			err = r.flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// openFile returns the log at path (and its rotated segments) filtered by opts.Start/End, limited
+// to the last opts.Length lines and rendered in opts.Format. With opts.Follow the reader streams new
+// lines until ctx is cancelled, or (with opts.UntilExit) until exited reports true.
+func openFile(ctx context.Context, path string, r *renderer, exited func() bool) (io.ReadCloser, error) {
+	opts := r.opts
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	older, err := segments(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.Start != nil {
+		older = newerThan(older, *opts.Start)
+	}
+	skip := 0
+	// Timestamps are monotonic, so a Start filter keeps a suffix of the log and can be applied after
+	// seeking to the last Length lines. Other filters need a full scan (see renderer.fullScan).
+	if opts.Length > 0 && !r.fullScan() {
+		off, lines, err := TailOffset(f, opts.Length)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		older, skip, err = tailSegments(older, opts.Length-lines)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	pr, pw := io.Pipe()
+	r.w, r.skip = bufio.NewWriter(pw), skip
+	go func() {
+		err := r.copySegments(older)
+		if err == nil {
+			err = r.copy(f, !opts.Follow)
+		}
+		if err == nil {
+			err = r.flush()
+		}
+		if err == nil && opts.Follow {
+			var until func() bool
+			if opts.UntilExit {
+				until = exited
+			}
+			err = follow(ctx, path, f, r, until)
+		} else {
+			f.Close()
+		}
+		if errors.Is(err, errPastEnd) {
+			err = r.flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// renderer parses lines, applies the filters, length limit and format, and writes them out.
+type renderer struct {
+	opts    backend.LogOptions
+	parser  *parser
+	filter  *filter
+	meta    Metadata
+	w       *bufio.Writer
+	ring    []string          // last Length matching lines during a full scan
+	last    time.Time         // timestamp of the previous stamped line
+	partial string            // unterminated tail of the last read, completed by the next one
+	skip    int               // leading lines to drop (tail across rotated segments)
+	pending map[string]*Entry // per-stream P records waiting for their final F record
+}
+
+func newRenderer(src Source, opts backend.LogOptions) (*renderer, error) {
+	p, err := newParser(src.Parser)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFilter(opts.Level, opts.Grep, opts.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return &renderer{opts: opts, parser: p, filter: f, meta: src.Meta}, nil
+}
+
+// fullScan reports whether the last Length lines can only be found by filtering every line
+// (keeping a ring of matches) rather than by seeking to the end of the log.
+func (r *renderer) fullScan() bool {
+	return r.opts.End != nil || r.filter.active()
+}
+
+// copy reads lines from r until EOF. An unterminated final line is emitted only when final is set;
+// otherwise it is kept until the rest of it arrives.
+func (r *renderer) copy(src io.Reader, final bool) error {
+	br := bufio.NewReaderSize(src, 64*1024)
+	for {
+		chunk, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF {
+			r.partial += chunk
+			if final && r.partial != "" {
+				line := r.partial
+				r.partial = ""
+				return r.line(line)
+			}
+			return nil
+		}
+		line := r.partial + strings.TrimSuffix(chunk, "\n")
+		r.partial = ""
+		if err := r.line(line); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *renderer) line(line string) error {
+	if r.skip > 0 {
+		r.skip--
+		return nil
+	}
+	e, tag := ParseLine(line)
+	switch tag {
+	case tagPartial:
+		if p := r.pending[e.Stream]; p != nil {
+			p.Message += e.Message
+		} else {
+			if r.pending == nil {
+				r.pending = map[string]*Entry{}
+			}
+			r.pending[e.Stream] = &e
+		}
+		return nil
+	case tagFull:
+		if p := r.pending[e.Stream]; p != nil {
+			p.Message += e.Message
+			e = *p
+			delete(r.pending, e.Stream)
+		}
+	}
+	// Raw lines without a timestamp (continuations, stack traces) inherit the previous one.
+	if e.Timestamp.IsZero() {
+		e.Timestamp = r.last
+	} else {
+		r.last = e.Timestamp
+	}
+	if r.opts.Start != nil || r.opts.End != nil {
+		if e.Timestamp.IsZero() {
+			return nil
+		}
+		if r.opts.Start != nil && e.Timestamp.Before(*r.opts.Start) {
+			return nil
+		}
+		if r.opts.End != nil && e.Timestamp.After(*r.opts.End) {
+			return errPastEnd
+		}
+	}
+	text := e.Message
+	if tag == "" {
+		// Raw lines are shown as they were written.
+		text = line
+	}
+	r.parser.parse(&e)
+	if !r.filter.match(&e) {
+		return nil
+	}
+	out, err := r.format(e, text)
+	if err != nil {
+		return err
+	}
+	if r.opts.Length > 0 && r.fullScan() {
+		r.ring = append(r.ring, out)
+		if len(r.ring) > r.opts.Length {
+			r.ring = r.ring[1:]
+		}
+		return nil
+	}
+	_, err = r.w.WriteString(out)
+	return err
+}
+
+// flush writes any buffered ring lines and flushes the writer.
+func (r *renderer) flush() error {
+	for _, out := range r.ring {
+		if _, err := r.w.WriteString(out); err != nil {
+			return err
+		}
+	}
+	r.ring = nil
+	return r.w.Flush()
+}
+
+// format renders one entry; "json" emits one flat object per line: the parsed fields merged with
+// the entry and the runtime metadata. Those keys win; a parsed field of the same name is kept as
+// "field.<name>". Anything else gets the text.
+func (r *renderer) format(e Entry, text string) (string, error) {
+	if r.opts.Format != "json" {
+		return text + "\n", nil
+	}
+	b, err := json.Marshal(struct {
+		Entry
+		Metadata
+	}{e, r.meta})
+	if err != nil {
+		return "", err
+	}
+	if len(e.Fields) == 0 {
+		return string(b) + "\n", nil
+	}
+	obj := map[string]any{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return "", err
+	}
+	for k, v := range e.Fields {
+		if _, taken := obj[k]; taken {
+			k = "field." + k
+		}
+		obj[k] = v
+	}
+	if b, err = json.Marshal(obj); err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// TailOffset returns the offset of the first of the last n lines in f by reading backwards in chunks,
+// and how many lines that covers (fewer than n when the file is shorter).
+func TailOffset(f *os.File, n int) (int64, int, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	const chunk = 8192
+	buf := make([]byte, chunk)
+	pos := info.Size()
+	seen := 0
+	for pos > 0 {
+		size := int64(chunk)
+		if pos < size {
+			size = pos
+		}
+		pos -= size
+		if _, err := f.ReadAt(buf[:size], pos); err != nil && err != io.EOF {
+			return 0, 0, err
+		}
+		for i := size - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			// A newline terminating the final line does not start a new line.
+			if pos+i == info.Size()-1 {
+				continue
+			}
+			seen++
+			if seen == n {
+				return pos + i + 1, n, nil
+			}
+		}
+	}
+	if info.Size() == 0 {
+		return 0, 0, nil
+	}
+	return 0, seen + 1, nil
+}
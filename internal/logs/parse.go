@@ -0,0 +1,271 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parser names accepted by --log-parser; a regex parser is given as "regex:<expr>" with named
+// groups (level, msg or message, time or ts; any other group becomes a field).
+const (
+	ParserAuto   = "auto" // JSON lines are parsed, anything else stays opaque text
+	ParserJSON   = "json"
+	ParserLogfmt = "logfmt"
+	ParserNone   = "none"
+	parserRegex  = "regex:"
+)
+
+// Common keys used by zap, logrus, slog and friends.
+var (
+	levelKeys = []string{"level", "lvl", "severity"}
+	msgKeys   = []string{"msg", "message"}
+	timeKeys  = []string{"ts", "time", "timestamp"}
+)
+
+// levelRank orders normalized levels for --level filtering.
+var levelRank = map[string]int{"trace": 0, "debug": 1, "info": 2, "warn": 3, "error": 4, "fatal": 5}
+
+// NormalizeLevel maps the spellings used by common loggers to trace|debug|info|warn|error|fatal.
+func NormalizeLevel(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "trace":
+		return "trace"
+	case "debug", "dbg":
+		return "debug"
+	case "info", "information", "notice":
+		return "info"
+	case "warn", "warning":
+		return "warn"
+	case "error", "err":
+		return "error"
+	case "fatal", "panic", "dpanic", "critical", "crit", "alert", "emerg":
+		return "fatal"
+	}
+	return ""
+}
+
+// parser extracts structured fields from a message.
+type parser struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// newParser validates a --log-parser value; empty means auto.
+func newParser(spec string) (*parser, error) {
+	switch {
+	case spec == "" || spec == ParserAuto:
+		return &parser{kind: ParserAuto}, nil
+	case spec == ParserJSON || spec == ParserLogfmt || spec == ParserNone:
+		return &parser{kind: spec}, nil
+	case strings.HasPrefix(spec, parserRegex):
+		re, err := regexp.Compile(strings.TrimPrefix(spec, parserRegex))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log parser regex: %w", err)
+		}
+		return &parser{kind: parserRegex, re: re}, nil
+	}
+	return nil, fmt.Errorf("invalid log parser %q (want auto | json | logfmt | none | regex:<expr>)", spec)
+}
+
+// ValidateParser reports whether spec is a valid --log-parser value.
+func ValidateParser(spec string) error {
+	_, err := newParser(spec)
+	return err
+}
+
+// parse fills e.Level, e.LoggedAt, e.Fields and (for structured lines) replaces e.Message with the
+// extracted message. Lines that do not match the parser are left as they are.
+func (p *parser) parse(e *Entry) {
+	var fields map[string]any
+	switch p.kind {
+	case ParserAuto, ParserJSON:
+		if !strings.HasPrefix(strings.TrimSpace(e.Message), "{") {
+			return
+		}
+		if err := json.Unmarshal([]byte(e.Message), &fields); err != nil {
+			return
+		}
+	case ParserLogfmt:
+		fields = parseLogfmt(e.Message)
+	case parserRegex:
+		m := p.re.FindStringSubmatch(e.Message)
+		if m == nil {
+			return
+		}
+		fields = map[string]any{}
+		for i, name := range p.re.SubexpNames() {
+			if name != "" && m[i] != "" {
+				fields[name] = m[i]
+			}
+		}
+	default:
+		return
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if v, ok := takeField(fields, levelKeys); ok {
+		e.Level = NormalizeLevel(fmt.Sprint(v))
+	}
+	if v, ok := takeField(fields, msgKeys); ok {
+		e.Message = fmt.Sprint(v)
+	}
+	if v, ok := takeField(fields, timeKeys); ok {
+		e.LoggedAt = parseTimeField(v)
+	}
+	if len(fields) > 0 {
+		e.Fields = fields
+	}
+}
+
+// takeField removes and returns the first of keys present in fields.
+func takeField(fields map[string]any, keys []string) (any, bool) {
+	for _, k := range keys {
+		if v, ok := fields[k]; ok {
+			delete(fields, k)
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// parseTimeField accepts RFC3339 strings and epoch seconds (as zap's default encoder writes).
+func parseTimeField(v any) time.Time {
+	switch t := v.(type) {
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return ts
+		}
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return epoch(f)
+		}
+	case float64:
+		return epoch(t)
+	}
+	return time.Time{}
+}
+
+func epoch(f float64) time.Time {
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC()
+}
+
+// parseLogfmt parses key=value pairs; values may be double-quoted. Bare keys get the value true.
+func parseLogfmt(s string) map[string]any {
+	fields := map[string]any{}
+	pairs := 0
+	for i := 0; i < len(s); {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' {
+			i++
+		}
+		key := s[start:i]
+		if key == "" {
+			i++
+			continue
+		}
+		if i >= len(s) || s[i] == ' ' {
+			fields[key] = true
+			continue
+		}
+		i++ // '='
+		pairs++
+		if i < len(s) && s[i] == '"' {
+			end := i + 1
+			for end < len(s) && (s[end] != '"' || s[end-1] == '\\') {
+				end++
+			}
+			if end >= len(s) {
+				// Unterminated quote: not logfmt.
+				return nil
+			}
+			if v, err := strconv.Unquote(s[i : end+1]); err == nil {
+				fields[key] = v
+			} else {
+				fields[key] = s[i+1 : end]
+			}
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(s) && s[i] != ' ' {
+			i++
+		}
+		fields[key] = s[start:i]
+	}
+	if pairs == 0 {
+		// Plain text, not logfmt.
+		return nil
+	}
+	return fields
+}
+
+// filter holds the compiled --level, --grep and --field conditions.
+type filter struct {
+	minLevel int // -1 = no level filter
+	grep     *regexp.Regexp
+	fields   map[string]string
+}
+
+func newFilter(level, grep string, fields map[string]string) (*filter, error) {
+	f := &filter{minLevel: -1, fields: fields}
+	if level != "" {
+		n := NormalizeLevel(level)
+		if n == "" {
+			return nil, fmt.Errorf("invalid level %q (want trace|debug|info|warn|error|fatal)", level)
+		}
+		f.minLevel = levelRank[n]
+	}
+	if grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grep expression: %w", err)
+		}
+		f.grep = re
+	}
+	return f, nil
+}
+
+// active reports whether any condition is set.
+func (f *filter) active() bool {
+	return f.minLevel >= 0 || f.grep != nil || len(f.fields) > 0
+}
+
+// match reports whether e passes all conditions. Lines without a level never pass a level filter.
+func (f *filter) match(e *Entry) bool {
+	if f.minLevel >= 0 {
+		rank, ok := levelRank[e.Level]
+		if !ok || rank < f.minLevel {
+			return false
+		}
+	}
+	if f.grep != nil && !f.grep.MatchString(e.Message) {
+		return false
+	}
+	for k, want := range f.fields {
+		var got string
+		switch k {
+		case "level":
+			got = e.Level
+		case "stream":
+			got = e.Stream
+		default:
+			v, ok := e.Fields[k]
+			if !ok {
+				return false
+			}
+			got = fmt.Sprint(v)
+		}
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tomatopunk/agent-runtime/internal/resources"
+)
+
+// Pipeline is the shim side of plugin logging: stdout/stderr writers that split output into
+// records, cap line length, rate-limit and fan out to the configured drivers.
+type Pipeline struct {
+	drv    Driver
+	stdout *StreamWriter
+	stderr *StreamWriter
+}
+
+// NewPipeline builds the drivers and the limiter described by cfg.
+func NewPipeline(cfg DriverConfig) (*Pipeline, error) {
+	maxLine := 0
+	if cfg.Limits.MaxLineSize != "" {
+		n, err := resources.ParseBytes(cfg.Limits.MaxLineSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log max line size: %w", err)
+		}
+		maxLine = int(n)
+	}
+	var lines, bytes *bucket
+	if cfg.Limits.LinesPerSec < 0 || cfg.Limits.LineBurst < 0 {
+		return nil, fmt.Errorf("invalid log line rate %g (burst %d)", cfg.Limits.LinesPerSec, cfg.Limits.LineBurst)
+	}
+	if cfg.Limits.LinesPerSec > 0 {
+		lines = newBucket(cfg.Limits.LinesPerSec, float64(cfg.Limits.LineBurst), 1)
+	}
+	if cfg.Limits.BytesPerSec != "" {
+		rate, err := resources.ParseBytes(cfg.Limits.BytesPerSec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log byte rate: %w", err)
+		}
+		var burst int64
+		if cfg.Limits.ByteBurst != "" {
+			if burst, err = resources.ParseBytes(cfg.Limits.ByteBurst); err != nil {
+				return nil, fmt.Errorf("invalid log byte burst: %w", err)
+			}
+		}
+		bytes = newBucket(float64(rate), float64(burst), float64(maxRecordLen(maxLine)))
+	}
+	drv, err := NewDriver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if lines != nil || bytes != nil {
+		drv = newLimiter(drv, lines, bytes, cfg.OnDropped)
+	}
+	return &Pipeline{
+		drv:    drv,
+		stdout: NewStreamWriter(drv, StreamStdout, maxLine),
+		stderr: NewStreamWriter(drv, StreamStderr, maxLine),
+	}, nil
+}
+
+// maxRecordLen is the longest record line a StreamWriter with the given max line size emits.
+func maxRecordLen(maxLine int) int {
+	if maxLine > 0 && maxLine+len(truncatedMarker) < maxRecordSize {
+		return maxLine + len(truncatedMarker)
+	}
+	return maxRecordSize
+}
+
+// Stdout is the writer for the plugin's standard output.
+func (p *Pipeline) Stdout() io.Writer { return p.stdout }
+
+// Stderr is the writer for the plugin's standard error.
+func (p *Pipeline) Stderr() io.Writer { return p.stderr }
+
+// Close flushes unterminated lines and closes the drivers; call it after the plugin has exited.
+func (p *Pipeline) Close() error {
+	p.stdout.Close()
+	p.stderr.Close()
+	return p.drv.Close()
+}
@@ -0,0 +1,190 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// defaultRingSize is the number of records the ring driver keeps when ring-size is not set.
+const defaultRingSize = 1000
+
+// ringDriver keeps the last records in memory and serves them, in CRI format, on a unix socket
+// owned by the shim. Nothing is written to disk; the records are gone once the shim exits.
+type ringDriver struct {
+	ln     *net.UnixListener
+	socket string
+
+	mu     sync.Mutex
+	recs   []Record
+	head   int // index of the oldest record once the ring is full
+	size   int
+	subs   map[chan Record]struct{}
+	closed bool
+}
+
+// ringRequest is the single JSON line a client sends after connecting.
+type ringRequest struct {
+	Follow bool `json:"follow"`
+	Tail   int  `json:"tail,omitempty"` // send only the last Tail buffered records
+}
+
+func newRingDriver(socket, size string) (*ringDriver, error) {
+	n := defaultRingSize
+	if size != "" {
+		var err error
+		if n, err = strconv.Atoi(size); err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid ring-size %q", size)
+		}
+	}
+	// The socket serves the plugin's output: only the runtime's user may connect.
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(socket) // stale socket from a previous shim
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	d := &ringDriver{ln: ln, socket: socket, size: n, subs: map[chan Record]struct{}{}}
+	go d.serve()
+	return d, nil
+}
+
+func (d *ringDriver) Log(rec Record) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.recs) < d.size {
+		d.recs = append(d.recs, rec)
+	} else {
+		d.recs[d.head] = rec
+		d.head = (d.head + 1) % d.size
+	}
+	for ch := range d.subs {
+		select {
+		case ch <- rec:
+		default:
+			// Too slow to keep up; end its stream rather than block the plugin.
+			delete(d.subs, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+func (d *ringDriver) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for ch := range d.subs {
+		close(ch)
+	}
+	d.subs = nil
+	d.mu.Unlock()
+	err := d.ln.Close()
+	_ = os.Remove(d.socket)
+	return err
+}
+
+func (d *ringDriver) serve() {
+	for {
+		conn, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *ringDriver) handle(conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var req ringRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return
+	}
+	d.mu.Lock()
+	snapshot := make([]Record, 0, len(d.recs))
+	snapshot = append(snapshot, d.recs[d.head:]...)
+	snapshot = append(snapshot, d.recs[:d.head]...)
+	if req.Tail > 0 && len(snapshot) > req.Tail {
+		snapshot = snapshot[len(snapshot)-req.Tail:]
+	}
+	var ch chan Record
+	if req.Follow && !d.closed {
+		ch = make(chan Record, 256)
+		d.subs[ch] = struct{}{}
+	}
+	d.mu.Unlock()
+	w := bufio.NewWriter(conn)
+	var buf []byte
+	for _, rec := range snapshot {
+		buf = AppendCRI(buf[:0], rec)
+		if _, err := w.Write(buf); err != nil {
+			d.unsubscribe(ch)
+			return
+		}
+	}
+	if err := w.Flush(); err != nil || ch == nil {
+		d.unsubscribe(ch)
+		return
+	}
+	for rec := range ch {
+		buf = AppendCRI(buf[:0], rec)
+		if _, err := conn.Write(buf); err != nil {
+			d.unsubscribe(ch)
+			return
+		}
+	}
+}
+
+func (d *ringDriver) unsubscribe(ch chan Record) {
+	if ch == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subs[ch]; ok {
+		delete(d.subs, ch)
+		close(ch)
+	}
+}
+
+// dialRing connects to a shim's ring driver and returns the stream of CRI records.
+// Without follow the stream ends after the buffered records; with follow it ends when ctx is
+// cancelled or the shim exits.
+func dialRing(ctx context.Context, socket string, follow bool, tail int) (io.ReadCloser, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("connect to log ring (is the plugin running?): %w", err)
+	}
+	req, _ := json.Marshal(ringRequest{Follow: follow, Tail: tail})
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if follow {
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+	}
+	return conn, nil
+}
@@ -0,0 +1,322 @@
+package logs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/resources"
+)
+
+// Rotation defaults used when neither the plugin nor the runtime config sets a value.
+const (
+	DefaultMaxSize  = "10Mi"
+	DefaultMaxFiles = 5
+)
+
+// RotatingWriter appends to path and rotates it once it reaches the size limit:
+// path -> path.1 (gzipped to path.1.gz when compression is on), older segments shift up
+// and anything beyond the retention count is removed. Compression runs in the background, so a
+// write never waits for it.
+type RotatingWriter struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+	// shared is set when other processes append to path too (see NewSharedRotatingWriter).
+	shared bool
+	// OnError reports rotation failures; Write keeps appending to the current file after one.
+	OnError func(error)
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// gzipping is the background compression of path.1, if any; rotation waits for it before
+	// shifting the segments again.
+	gzipping chan struct{}
+}
+
+// NewRotatingWriter opens (appending) the live log file at path. The writer must be the file's
+// only writer, as the shim is for its plugin's log.
+func NewRotatingWriter(path string, r backend.LogRotation) (*RotatingWriter, error) {
+	maxFiles := DefaultMaxFiles
+	r = r.Merge(backend.LogRotation{MaxSize: DefaultMaxSize, MaxFiles: &maxFiles})
+	maxSize, err := resources.ParseBytes(r.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log max size: %w", err)
+	}
+	if *r.MaxFiles < 0 {
+		return nil, fmt.Errorf("invalid log max files %d", *r.MaxFiles)
+	}
+	w := &RotatingWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: *r.MaxFiles,
+		compress: r.Compress == nil || *r.Compress,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewSharedRotatingWriter is NewRotatingWriter for a file several processes append to, such as the
+// runtime log every shim writes. Writes go to whatever file is at path (one that another process
+// rotated away is reopened) and one process at a time rotates, holding path.lock until the old
+// segment is compressed.
+func NewSharedRotatingWriter(path string, r backend.LogRotation) (*RotatingWriter, error) {
+	w, err := NewRotatingWriter(path, r)
+	if err != nil {
+		return nil, err
+	}
+	w.shared = true
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would push the live file past the limit. A failed rotation
+// goes to OnError and the write still lands in the live file, which then grows past the limit until
+// a later rotation succeeds.
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	if w.shared {
+		if err := w.follow(); err != nil {
+			return 0, err
+		}
+	}
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			w.reportError(fmt.Errorf("rotate %s: %w", w.path, err))
+		}
+		if w.f == nil {
+			if err := w.open(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// follow reopens path if another process rotated the open file away, and refreshes the size,
+// which the other writers grow too.
+func (w *RotatingWriter) follow() error {
+	moved, err := w.moved()
+	if err != nil {
+		return err
+	}
+	if moved {
+		w.f.Close()
+		w.f = nil
+		return w.open()
+	}
+	info, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	w.size = info.Size()
+	return nil
+}
+
+// moved reports whether path no longer names the open file.
+func (w *RotatingWriter) moved() (bool, error) {
+	cur, err := os.Stat(w.path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	open, err := w.f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(cur, open), nil
+}
+
+// Sync waits for a pending compression, so a process that exits after it leaves no segment
+// half-compressed.
+func (w *RotatingWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.gzipping != nil {
+		<-w.gzipping
+		w.gzipping = nil
+	}
+	return nil
+}
+
+// Close closes the live file and waits for a pending compression.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.gzipping != nil {
+		<-w.gzipping
+		w.gzipping = nil
+	}
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func (w *RotatingWriter) reportError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+func (w *RotatingWriter) rotate() error {
+	if w.gzipping != nil {
+		// path.1 is about to move; let its compression finish first. This only waits when
+		// rotations come faster than one segment compresses.
+		<-w.gzipping
+		w.gzipping = nil
+	}
+	var lock *os.File
+	if w.shared {
+		var err error
+		if lock, err = lockFile(w.path + ".lock"); err != nil {
+			return err
+		}
+		// Another process may have rotated while this one waited for the lock.
+		if moved, err := w.moved(); err != nil || moved {
+			lock.Close()
+			if err != nil {
+				return err
+			}
+			w.f.Close()
+			w.f = nil
+			return w.open()
+		}
+	}
+	// unlock releases the rotation lock, if held, once the segments are settled.
+	unlock := func() {
+		if lock != nil {
+			lock.Close()
+		}
+	}
+	if err := w.f.Close(); err != nil {
+		unlock()
+		return err
+	}
+	w.f = nil
+	if w.maxFiles == 0 {
+		defer unlock()
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return w.open()
+	}
+	// Drop the oldest segment, then shift the rest up by one.
+	for _, ext := range []string{"", ".gz"} {
+		_ = os.Remove(segmentName(w.path, w.maxFiles) + ext)
+	}
+	for i := w.maxFiles - 1; i >= 1; i-- {
+		for _, ext := range []string{"", ".gz"} {
+			if err := os.Rename(segmentName(w.path, i)+ext, segmentName(w.path, i+1)+ext); err != nil && !os.IsNotExist(err) {
+				unlock()
+				return err
+			}
+		}
+	}
+	first := segmentName(w.path, 1)
+	if err := os.Rename(w.path, first); err != nil {
+		unlock()
+		return err
+	}
+	if err := w.open(); err != nil {
+		unlock()
+		return err
+	}
+	if !w.compress {
+		unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	w.gzipping = done
+	go func() {
+		defer close(done)
+		defer unlock()
+		if err := gzipFile(first); err != nil {
+			w.reportError(fmt.Errorf("compress %s: %w", first, err))
+		}
+	}()
+	return nil
+}
+
+// lockFile takes an exclusive flock on path, creating it if needed; closing the file releases it.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// segmentName returns the name of the i-th rotated segment (1 = newest).
+func segmentName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// gzipFile compresses path to path.gz and removes path.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,152 @@
+package logs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segments returns the rotated segments of path (path.N[.gz] ... path.1[.gz]), oldest first. While a
+// segment is being compressed both path.N and path.N.gz exist; only path.N is listed, so its lines
+// are read once.
+func segments(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	type seg struct {
+		name  string
+		index int
+	}
+	var segs []seg
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		i, err := strconv.Atoi(suffix)
+		if err != nil || i <= 0 {
+			continue
+		}
+		if strings.HasSuffix(m, ".gz") {
+			if _, err := os.Stat(strings.TrimSuffix(m, ".gz")); err == nil {
+				continue
+			}
+		}
+		segs = append(segs, seg{m, i})
+	}
+	sort.Slice(segs, func(a, b int) bool { return segs[a].index > segs[b].index })
+	out := make([]string, 0, len(segs))
+	for _, s := range segs {
+		out = append(out, s.name)
+	}
+	return out, nil
+}
+
+// newerThan drops segments last written before t; none of their lines can match a Start filter.
+func newerThan(segs []string, t time.Time) []string {
+	var out []string
+	for _, s := range segs {
+		if info, err := os.Stat(s); err == nil && info.ModTime().Before(t) {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// tailSegments keeps only the newest segments needed to supply need more lines, and returns how many
+// leading lines of the oldest kept segment to skip.
+func tailSegments(segs []string, need int) ([]string, int, error) {
+	if need <= 0 {
+		return nil, 0, nil
+	}
+	for i := len(segs) - 1; i >= 0; i-- {
+		n, err := countLines(segs[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		if n >= need {
+			return segs[i:], n - need, nil
+		}
+		need -= n
+	}
+	return segs, 0, nil
+}
+
+// countLines counts newline-terminated lines in a segment.
+func countLines(name string) (int, error) {
+	rc, err := openSegment(name)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	buf := make([]byte, 32*1024)
+	n := 0
+	for {
+		c, err := rc.Read(buf)
+		n += bytes.Count(buf[:c], []byte{'\n'})
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// openSegment opens a rotated segment, decompressing .gz transparently. An uncompressed segment
+// that was compressed since it was listed is opened as name.gz.
+func openSegment(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) && !strings.HasSuffix(name, ".gz") {
+		name += ".gz"
+		f, err = os.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipSegment{Reader: zr, f: f}, nil
+}
+
+type gzipSegment struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipSegment) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// copySegments feeds the rotated segments to the renderer in order; a line split across a rotation
+// boundary is joined with its continuation in the next segment.
+func (r *renderer) copySegments(segs []string) error {
+	for _, s := range segs {
+		rc, err := openSegment(s)
+		if err != nil {
+			// Rotated away while we were reading (a compressed one is retried by openSegment); skip it.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		err = r.copy(rc, false)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package logs
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// syslogDriver sends each record to syslog: stdout at LOG_INFO, stderr at LOG_ERR, tagged with the plugin id.
+type syslogDriver struct {
+	w *syslog.Writer
+}
+
+// newSyslogDriver dials addr, given as "unixgram:///dev/log", "unix:///path", "udp://host:514" or
+// "tcp://host:514"; an empty addr uses the local syslog socket (/dev/log).
+func newSyslogDriver(addr, pluginID string) (*syslogDriver, error) {
+	network, raddr := "", ""
+	if addr != "" {
+		var ok bool
+		network, raddr, ok = strings.Cut(addr, "://")
+		if !ok {
+			return nil, fmt.Errorf("invalid syslog-address %q: want network://address", addr)
+		}
+		switch network {
+		case "unix", "unixgram", "udp", "tcp":
+		default:
+			return nil, fmt.Errorf("invalid syslog-address %q: network must be unix, unixgram, udp or tcp", addr)
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_USER|syslog.LOG_INFO, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogDriver{w: w}, nil
+}
+
+func (d *syslogDriver) Log(rec Record) error {
+	if rec.Stream == StreamStderr {
+		return d.w.Err(string(rec.Line))
+	}
+	return d.w.Info(string(rec.Line))
+}
+
+func (d *syslogDriver) Close() error {
+	return d.w.Close()
+}
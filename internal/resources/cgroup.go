@@ -0,0 +1,132 @@
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CgroupRoot is the cgroup v2 parent under which the binary backend creates per-plugin cgroups.
+const CgroupRoot = "/sys/fs/cgroup/agent-runtime"
+
+// CgroupPath returns the cgroup v2 directory for a plugin.
+func CgroupPath(pluginID string) string {
+	return filepath.Join(CgroupRoot, pluginID)
+}
+
+// cgroupFile is one interface file write; order matters (cpuset before tasks, memory.max before memory.high).
+type cgroupFile struct {
+	name  string
+	value string
+}
+
+// cgroupFiles returns the cgroup v2 interface files to write for the spec.
+func (s *Spec) cgroupFiles() []cgroupFile {
+	var files []cgroupFile
+	if s.CpusetCPUs != "" {
+		files = append(files, cgroupFile{"cpuset.cpus", s.CpusetCPUs})
+	}
+	if s.CpusetMems != "" {
+		files = append(files, cgroupFile{"cpuset.mems", s.CpusetMems})
+	}
+	if s.CPUQuota > 0 {
+		files = append(files, cgroupFile{"cpu.max", fmt.Sprintf("%d %d", s.CPUQuota, s.CPUPeriod)})
+	}
+	if s.CPUShares > 0 {
+		files = append(files, cgroupFile{"cpu.weight", strconv.FormatUint(SharesToWeight(s.CPUShares), 10)})
+	}
+	if s.PidsMax > 0 {
+		files = append(files, cgroupFile{"pids.max", strconv.FormatInt(s.PidsMax, 10)})
+	}
+	if s.IOWeight > 0 {
+		files = append(files, cgroupFile{"io.weight", "default " + strconv.FormatUint(BlkioToIOWeight(s.IOWeight), 10)})
+	}
+	for _, l := range s.IOLimits {
+		files = append(files, cgroupFile{"io.max", l.ioMax()})
+	}
+	if s.MemoryMin > 0 {
+		files = append(files, cgroupFile{"memory.min", strconv.FormatInt(s.MemoryMin, 10)})
+	}
+	if s.MemoryMax > 0 {
+		files = append(files, cgroupFile{"memory.max", strconv.FormatInt(s.MemoryMax, 10)})
+	}
+	if s.MemoryHigh > 0 {
+		files = append(files, cgroupFile{"memory.high", strconv.FormatInt(s.MemoryHigh, 10)})
+	}
+	if s.MemorySwap > 0 {
+		files = append(files, cgroupFile{"memory.swap.max", strconv.FormatInt(s.MemorySwap, 10)})
+	}
+	return files
+}
+
+func (l IOLimit) ioMax() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:%d", l.Major, l.Minor)
+	for _, kv := range []struct {
+		key string
+		val uint64
+	}{{"rbps", l.ReadBps}, {"wbps", l.WriteBps}, {"riops", l.ReadIOPS}, {"wiops", l.WriteIOPS}} {
+		if kv.val > 0 {
+			fmt.Fprintf(&b, " %s=%d", kv.key, kv.val)
+		}
+	}
+	return b.String()
+}
+
+// SharesToWeight converts cgroup v1 cpu.shares to cgroup v2 cpu.weight (same formula as runc/crun).
+func SharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// BlkioToIOWeight converts a blkio weight (10-1000) to cgroup v2 io.weight (1-10000).
+func BlkioToIOWeight(w uint16) uint64 {
+	if w == 0 {
+		return 0
+	}
+	return 1 + (uint64(w)-10)*9999/990
+}
+
+// CreateCgroup creates the plugin's cgroup v2 dir, enables the needed controllers on the parents
+// and writes the limits. The returned dir is meant for SysProcAttr.CgroupFD; the caller closes it.
+func CreateCgroup(pluginID string, s *Spec) (*os.File, error) {
+	files := s.cgroupFiles()
+	controllers := map[string]bool{}
+	for _, f := range files {
+		controllers[strings.SplitN(f.name, ".", 2)[0]] = true
+	}
+	if err := os.MkdirAll(CgroupRoot, 0755); err != nil {
+		return nil, fmt.Errorf("create cgroup root: %w", err)
+	}
+	for _, parent := range []string{filepath.Dir(CgroupRoot), CgroupRoot} {
+		for c := range controllers {
+			if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+c), 0); err != nil {
+				return nil, fmt.Errorf("enable %s controller in %s: %w", c, parent, err)
+			}
+		}
+	}
+	dir := CgroupPath(pluginID)
+	if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.name), []byte(f.value), 0); err != nil {
+			return nil, fmt.Errorf("write %s=%q: %w", f.name, f.value, err)
+		}
+	}
+	return os.Open(dir)
+}
+
+// RemoveCgroup removes the plugin's cgroup dir; missing dirs are not an error.
+func RemoveCgroup(pluginID string) error {
+	err := os.Remove(CgroupPath(pluginID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
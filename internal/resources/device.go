@@ -0,0 +1,48 @@
+package resources
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Device is a host device node as seen by stat.
+type Device struct {
+	Path  string
+	Type  string // "c" (character) or "b" (block)
+	Major int64
+	Minor int64
+	Mode  uint32 // permission bits
+	UID   uint32
+	GID   uint32
+}
+
+// StatDevice returns the block or character device node at path.
+func StatDevice(path string) (Device, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return Device{}, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	d := Device{Path: path, Mode: st.Mode & 0o7777, UID: st.Uid, GID: st.Gid}
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		d.Type = "c"
+	case syscall.S_IFBLK:
+		d.Type = "b"
+	default:
+		return Device{}, fmt.Errorf("%s is not a device node", path)
+	}
+	rdev := uint64(st.Rdev)
+	d.Major = int64((rdev>>8)&0xfff | (rdev>>32)&^0xfff)
+	d.Minor = int64(rdev&0xff | (rdev>>12)&^0xff)
+	return d, nil
+}
+
+// DeviceNumbers returns the major/minor numbers of a block or character device node.
+func DeviceNumbers(path string) (major, minor int64, err error) {
+	d, err := StatDevice(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return d.Major, d.Minor, nil
+}
@@ -0,0 +1,160 @@
+package resources
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RlimitInfinity is an unlimited rlimit value (RLIM_INFINITY).
+const RlimitInfinity = math.MaxUint64
+
+// rlimitResources maps the accepted rlimit names to their resource numbers; byte-sized limits
+// accept the ParseBytes suffixes.
+var rlimitResources = map[string]struct {
+	resource int
+	bytes    bool
+}{
+	"nofile": {syscall.RLIMIT_NOFILE, false},
+	"nproc":  {6, false}, // RLIMIT_NPROC, not in package syscall
+	"core":   {syscall.RLIMIT_CORE, true},
+	"cpu":    {syscall.RLIMIT_CPU, false}, // seconds
+	"as":     {syscall.RLIMIT_AS, true},
+}
+
+// I/O scheduling classes (ioprio), by their ionice names.
+var ioPrioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// Real-time scheduling policies, by name.
+var schedPolicies = map[string]int{
+	"fifo": 1, // SCHED_FIFO
+	"rr":   2, // SCHED_RR
+}
+
+// Rlimit is one parsed resource limit.
+type Rlimit struct {
+	Name     string // nofile | nproc | core | cpu | as
+	Resource int    // RLIMIT_* number
+	Soft     uint64
+	Hard     uint64
+}
+
+// OCIType is the limit's OCI spec name, e.g. RLIMIT_NOFILE.
+func (r Rlimit) OCIType() string {
+	return "RLIMIT_" + strings.ToUpper(r.Name)
+}
+
+// parseProcess parses the per-process settings (rlimits, nice, ionice, real-time policy and CPU
+// affinity) into s.
+func (o Options) parseProcess(s *Spec) error {
+	for _, r := range o.Rlimits {
+		lim, err := parseRlimit(r)
+		if err != nil {
+			return err
+		}
+		s.Rlimits = append(s.Rlimits, lim)
+	}
+	if o.Nice != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(o.Nice))
+		if err != nil || n < -20 || n > 19 {
+			return fmt.Errorf("invalid nice %q: want an integer in -20..19", o.Nice)
+		}
+		s.Nice = &n
+	}
+	if o.IONice != "" {
+		class, level, hasLevel := strings.Cut(strings.TrimSpace(o.IONice), ":")
+		c, ok := ioPrioClasses[class]
+		if !ok {
+			return fmt.Errorf("invalid ionice %q: class must be realtime, best-effort or idle", o.IONice)
+		}
+		s.IOPrioClass, s.IOPrioLevel = c, 4
+		if c == ioPrioClasses["idle"] {
+			s.IOPrioLevel = 0
+		}
+		if hasLevel {
+			n, err := strconv.Atoi(level)
+			if err != nil || n < 0 || n > 7 || c == ioPrioClasses["idle"] {
+				return fmt.Errorf("invalid ionice %q: want CLASS[:0-7] (idle takes no level)", o.IONice)
+			}
+			s.IOPrioLevel = n
+		}
+	}
+	if o.Sched != "" {
+		policy, prio, _ := strings.Cut(strings.TrimSpace(o.Sched), ":")
+		p, ok := schedPolicies[policy]
+		n, err := strconv.Atoi(prio)
+		if !ok || err != nil || n < 1 || n > 99 {
+			return fmt.Errorf("invalid sched %q: want fifo:PRIO or rr:PRIO with PRIO in 1-99", o.Sched)
+		}
+		s.SchedPolicy, s.SchedPriority = p, n
+	}
+	if o.CPUAffinity != "" {
+		if err := validateCpuset(o.CPUAffinity); err != nil {
+			return fmt.Errorf("invalid cpu affinity: %w", err)
+		}
+		s.CPUAffinity = strings.TrimSpace(o.CPUAffinity)
+	}
+	return nil
+}
+
+// parseRlimit parses NAME=SOFT[:HARD]; a value is a number or "unlimited", and HARD defaults to SOFT.
+func parseRlimit(s string) (Rlimit, error) {
+	name, vals, ok := strings.Cut(strings.TrimSpace(s), "=")
+	res, known := rlimitResources[name]
+	if !ok || !known {
+		return Rlimit{}, fmt.Errorf("invalid rlimit %q: want NAME=SOFT[:HARD], NAME nofile|nproc|core|cpu|as", s)
+	}
+	value := func(v string) (uint64, error) {
+		if v == "unlimited" {
+			return RlimitInfinity, nil
+		}
+		if res.bytes {
+			n, err := ParseBytes(v)
+			return uint64(n), err
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	}
+	soft, hard, hasHard := strings.Cut(vals, ":")
+	lim := Rlimit{Name: name, Resource: res.resource}
+	var err error
+	if lim.Soft, err = value(soft); err != nil {
+		return Rlimit{}, fmt.Errorf("rlimit %q: %w", s, err)
+	}
+	lim.Hard = lim.Soft
+	if hasHard {
+		if lim.Hard, err = value(hard); err != nil {
+			return Rlimit{}, fmt.Errorf("rlimit %q: %w", s, err)
+		}
+	}
+	if lim.Soft > lim.Hard {
+		return Rlimit{}, fmt.Errorf("rlimit %q: soft limit exceeds hard limit", s)
+	}
+	return lim, nil
+}
+
+// CPUList expands a validated cpuset list such as "0-2,5" into CPU numbers.
+func CPUList(s string) []int {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, _ := strconv.Atoi(lo)
+		b := a
+		if isRange {
+			b, _ = strconv.Atoi(hi)
+		}
+		for c := a; c <= b; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus
+}
@@ -0,0 +1,243 @@
+package resources
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCPUPeriod is the cpu.max period (microseconds) used when converting a CPU count to a quota.
+const DefaultCPUPeriod uint64 = 100000
+
+// Options are the raw, user-facing resource settings (CLI flags, persisted in meta).
+// Parse turns them into a validated Spec; empty fields mean "no limit".
+type Options struct {
+	CPU        string   `json:"cpu,omitempty"`         // hard CPU limit (cpu.max) in cores, e.g. "0.5" or "500m"
+	CPUShares  string   `json:"cpu_shares,omitempty"`  // relative weight, cgroup v1 shares scale (2-262144)
+	CpusetCPUs string   `json:"cpuset_cpus,omitempty"` // e.g. "0-1,3"
+	CpusetMems string   `json:"cpuset_mems,omitempty"` // e.g. "0"
+	Pids       string   `json:"pids,omitempty"`        // pids.max
+	IOWeight   string   `json:"io_weight,omitempty"`   // blkio weight (10-1000)
+	IOLimits   []string `json:"io_limits,omitempty"`   // DEVICE:rbps=N:wbps=N:riops=N:wiops=N
+	Mem        string   `json:"mem,omitempty"`         // memory.max, e.g. "128Mi"
+	MemHigh    string   `json:"mem_high,omitempty"`    // memory.high (throttle threshold)
+	MemSwap    string   `json:"mem_swap,omitempty"`    // swap allowed in addition to Mem
+	MemMin     string   `json:"mem_min,omitempty"`     // memory.min (guaranteed reservation)
+	// Per-process settings, applied to the plugin process rather than its cgroup.
+	Rlimits     []string `json:"rlimits,omitempty"`      // NAME=SOFT[:HARD], NAME nofile|nproc|core|cpu|as
+	Nice        string   `json:"nice,omitempty"`         // -20..19
+	IONice      string   `json:"ionice,omitempty"`       // CLASS[:LEVEL], CLASS realtime|best-effort|idle, LEVEL 0-7
+	Sched       string   `json:"sched,omitempty"`        // real-time policy fifo:PRIO | rr:PRIO, PRIO 1-99
+	CPUAffinity string   `json:"cpu_affinity,omitempty"` // CPUs the process may run on, e.g. "0-1,3"
+}
+
+// LegacyOptions converts the cpu and mem of a meta.json written before Options existed. cpu was a
+// relative weight then (cores * 1024 shares), not a hard limit, so it becomes CPUShares; values the
+// old parser ignored are dropped, which leaves the defaults they fell back to.
+func LegacyOptions(cpu, mem string) Options {
+	var o Options
+	if f, err := strconv.ParseFloat(strings.TrimSpace(cpu), 64); err == nil && f > 0 && !math.IsInf(f, 0) {
+		o.CPUShares = strconv.FormatUint(uint64(min(max(f*1024, 2), 262144)), 10)
+	}
+	if _, err := ParseBytes(mem); err == nil {
+		o.Mem = strings.TrimSpace(mem)
+	}
+	return o
+}
+
+// Spec is the parsed resource spec; zero values mean "not set".
+type Spec struct {
+	CPUQuota   int64  // cpu.max quota in microseconds per CPUPeriod
+	CPUPeriod  uint64 // cpu.max period in microseconds
+	CPUShares  uint64 // cgroup v1 shares scale
+	CpusetCPUs string
+	CpusetMems string
+	PidsMax    int64
+	IOWeight   uint16 // blkio weight scale (10-1000)
+	IOLimits   []IOLimit
+	MemoryMax  int64 // bytes
+	MemoryHigh int64 // bytes
+	MemorySwap int64 // bytes of swap on top of MemoryMax
+	MemoryMin  int64 // bytes
+	// Per-process settings; IsZero does not consider them.
+	Rlimits       []Rlimit
+	Nice          *int
+	IOPrioClass   int // 1 realtime, 2 best-effort, 3 idle; 0 = not set
+	IOPrioLevel   int
+	SchedPolicy   int // 1 SCHED_FIFO, 2 SCHED_RR; 0 = not set
+	SchedPriority int
+	CPUAffinity   string
+}
+
+// IOLimit is a per-device bandwidth/iops throttle.
+type IOLimit struct {
+	Device    string
+	Major     int64
+	Minor     int64
+	ReadBps   uint64
+	WriteBps  uint64
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+// IsZero reports whether no cgroup limit is set.
+func (s *Spec) IsZero() bool {
+	return s == nil || (s.CPUQuota == 0 && s.CPUShares == 0 && s.CpusetCPUs == "" && s.CpusetMems == "" &&
+		s.PidsMax == 0 && s.IOWeight == 0 && len(s.IOLimits) == 0 &&
+		s.MemoryMax == 0 && s.MemoryHigh == 0 && s.MemorySwap == 0 && s.MemoryMin == 0)
+}
+
+// Parse validates the options strictly; any malformed value is an error (no silent defaults).
+func (o Options) Parse() (*Spec, error) {
+	s := &Spec{}
+	var err error
+	if o.CPU != "" {
+		if s.CPUQuota, err = ParseCPU(o.CPU, DefaultCPUPeriod); err != nil {
+			return nil, err
+		}
+		s.CPUPeriod = DefaultCPUPeriod
+	}
+	if o.CPUShares != "" {
+		n, err := strconv.ParseUint(strings.TrimSpace(o.CPUShares), 10, 64)
+		if err != nil || n < 2 || n > 262144 {
+			return nil, fmt.Errorf("invalid cpu shares %q: want an integer in 2-262144", o.CPUShares)
+		}
+		s.CPUShares = n
+	}
+	if o.CpusetCPUs != "" {
+		if err := validateCpuset(o.CpusetCPUs); err != nil {
+			return nil, fmt.Errorf("invalid cpuset cpus: %w", err)
+		}
+		s.CpusetCPUs = strings.TrimSpace(o.CpusetCPUs)
+	}
+	if o.CpusetMems != "" {
+		if err := validateCpuset(o.CpusetMems); err != nil {
+			return nil, fmt.Errorf("invalid cpuset mems: %w", err)
+		}
+		s.CpusetMems = strings.TrimSpace(o.CpusetMems)
+	}
+	if o.Pids != "" {
+		n, err := strconv.ParseInt(strings.TrimSpace(o.Pids), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid pids limit %q: want a positive integer", o.Pids)
+		}
+		s.PidsMax = n
+	}
+	if o.IOWeight != "" {
+		n, err := strconv.ParseUint(strings.TrimSpace(o.IOWeight), 10, 16)
+		if err != nil || n < 10 || n > 1000 {
+			return nil, fmt.Errorf("invalid io weight %q: want an integer in 10-1000", o.IOWeight)
+		}
+		s.IOWeight = uint16(n)
+	}
+	for _, l := range o.IOLimits {
+		lim, err := parseIOLimit(l)
+		if err != nil {
+			return nil, err
+		}
+		s.IOLimits = append(s.IOLimits, lim)
+	}
+	for _, m := range []struct {
+		name string
+		in   string
+		out  *int64
+	}{
+		{"mem", o.Mem, &s.MemoryMax},
+		{"mem high", o.MemHigh, &s.MemoryHigh},
+		{"mem swap", o.MemSwap, &s.MemorySwap},
+		{"mem min", o.MemMin, &s.MemoryMin},
+	} {
+		if m.in == "" {
+			continue
+		}
+		n, err := ParseBytes(m.in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", m.name, err)
+		}
+		*m.out = n
+	}
+	if err := o.parseProcess(s); err != nil {
+		return nil, err
+	}
+	if s.MemorySwap > 0 && s.MemoryMax == 0 {
+		return nil, fmt.Errorf("mem swap requires mem to be set")
+	}
+	if s.MemoryHigh > 0 && s.MemoryMax > 0 && s.MemoryHigh > s.MemoryMax {
+		return nil, fmt.Errorf("mem high (%d) exceeds mem (%d)", s.MemoryHigh, s.MemoryMax)
+	}
+	if s.MemoryMin > 0 && s.MemoryMax > 0 && s.MemoryMin > s.MemoryMax {
+		return nil, fmt.Errorf("mem min (%d) exceeds mem (%d)", s.MemoryMin, s.MemoryMax)
+	}
+	return s, nil
+}
+
+// parseIOLimit parses DEVICE:key=value[:key=value...], keys rbps|wbps|riops|wiops.
+func parseIOLimit(s string) (IOLimit, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || parts[0] == "" {
+		return IOLimit{}, fmt.Errorf("invalid io limit %q: want DEVICE:key=value[:key=value]", s)
+	}
+	lim := IOLimit{Device: parts[0]}
+	major, minor, err := DeviceNumbers(lim.Device)
+	if err != nil {
+		return IOLimit{}, fmt.Errorf("io limit %q: %w", s, err)
+	}
+	lim.Major, lim.Minor = major, minor
+	for _, kv := range parts[1:] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return IOLimit{}, fmt.Errorf("invalid io limit %q: %q is not key=value", s, kv)
+		}
+		switch k {
+		case "rbps", "wbps":
+			n, err := ParseBytes(v)
+			if err != nil {
+				return IOLimit{}, fmt.Errorf("io limit %q: %w", s, err)
+			}
+			if k == "rbps" {
+				lim.ReadBps = uint64(n)
+			} else {
+				lim.WriteBps = uint64(n)
+			}
+		case "riops", "wiops":
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || n == 0 {
+				return IOLimit{}, fmt.Errorf("io limit %q: invalid %s %q", s, k, v)
+			}
+			if k == "riops" {
+				lim.ReadIOPS = n
+			} else {
+				lim.WriteIOPS = n
+			}
+		default:
+			return IOLimit{}, fmt.Errorf("io limit %q: unknown key %q (want rbps|wbps|riops|wiops)", s, k)
+		}
+	}
+	return lim, nil
+}
+
+// validateCpuset checks the cpuset list syntax, e.g. "0-3,5,7-8".
+func validateCpuset(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return fmt.Errorf("empty list")
+	}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return fmt.Errorf("%q: %q is not a number", s, lo)
+		}
+		if isRange {
+			b, err := strconv.ParseUint(hi, 10, 16)
+			if err != nil {
+				return fmt.Errorf("%q: %q is not a number", s, hi)
+			}
+			if b < a {
+				return fmt.Errorf("%q: range %s is reversed", s, part)
+			}
+		}
+	}
+	return nil
+}
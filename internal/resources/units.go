@@ -0,0 +1,88 @@
+package resources
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteUnits maps accepted suffixes to multipliers.
+// IEC suffixes (Ki, Mi, Gi, Ti, and the KiB forms) are binary; SI suffixes with B (KB, MB, GB, TB) are decimal.
+// Single-letter k/m/g/t (either case) are kept binary for compatibility with the docker-style values the CLI accepted before.
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"k":   1 << 10,
+	"m":   1 << 20,
+	"g":   1 << 30,
+	"t":   1 << 40,
+}
+
+// ParseBytes parses a positive byte quantity such as "512Mi", "1.5Gi", "100MB" or "4096".
+// Unknown suffixes, negative and zero values are rejected.
+func ParseBytes(s string) (int64, error) {
+	in := strings.TrimSpace(s)
+	i := 0
+	for i < len(in) && (in[i] >= '0' && in[i] <= '9' || in[i] == '.') {
+		i++
+	}
+	num, unit := in[:i], strings.ToLower(in[i:])
+	if num == "" {
+		return 0, fmt.Errorf("%q: missing number", s)
+	}
+	mult, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("%q: unknown unit %q (want Ki|Mi|Gi|Ti, KB|MB|GB|TB or bytes)", s, in[i:])
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", s, err)
+	}
+	v := f * float64(mult)
+	// float64(math.MaxInt64) rounds up to 2^63, which no longer fits.
+	if v < 1 || v >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q: out of range", s)
+	}
+	return int64(v), nil
+}
+
+// ParseCPU parses a CPU count ("0.5", "2") or millicores ("500m") into a cpu.max quota for the given period.
+func ParseCPU(s string, period uint64) (int64, error) {
+	in := strings.TrimSpace(s)
+	var cores float64
+	if strings.HasSuffix(in, "m") {
+		n, err := strconv.ParseUint(strings.TrimSuffix(in, "m"), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu %q: %w", s, err)
+		}
+		cores = float64(n) / 1000
+	} else {
+		f, err := strconv.ParseFloat(in, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return 0, fmt.Errorf("invalid cpu %q: want cores (e.g. 0.5) or millicores (e.g. 500m)", s)
+		}
+		cores = f
+	}
+	if cores*float64(period) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid cpu %q: out of range", s)
+	}
+	quota := int64(cores * float64(period))
+	// The kernel rejects quotas below 1ms.
+	if quota < 1000 {
+		return 0, fmt.Errorf("invalid cpu %q: must be at least %.3f cores", s, 1000/float64(period))
+	}
+	return quota, nil
+}
@@ -2,12 +2,20 @@ package runtime
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/config"
+	"github.com/tomatopunk/agent-runtime/internal/image"
+	"github.com/tomatopunk/agent-runtime/internal/logger"
 	"github.com/tomatopunk/agent-runtime/internal/state"
+	"github.com/tomatopunk/agent-runtime/internal/verify"
+	"go.uber.org/zap"
 )
 
 // Run starts the plugin and returns immediately; lifecycle is managed by the caller (e.g. stop via separate CLI or upper layer).
@@ -19,30 +27,254 @@ func (r *Runtime) Run(ctx context.Context, backendName string, opts backend.RunO
 	if err != nil {
 		return err
 	}
+	cfg, err := config.Load(r.rootDir)
+	if err != nil {
+		return err
+	}
+	opts.LogRotation = opts.LogRotation.Merge(cfg.Log)
+	opts.LogLimits = opts.LogLimits.Merge(cfg.LogLimits)
+	if backendName != backend.BackendRunc {
+		if opts.Image != "" {
+			return fmt.Errorf("--image requires the runc backend")
+		}
+		if opts.BaseRootfs != "" || len(opts.SpecPatches) > 0 || opts.SpecFile != "" || opts.SecurityProfile != "" ||
+			len(opts.Devices) > 0 || len(opts.Mounts) > 0 || opts.HostDir {
+			return fmt.Errorf("--base-rootfs, --spec-patch, --spec-file, --security-profile, --device, --mount and --host-dir require the runc backend")
+		}
+		if err := opts.Isolation.Validate(); err != nil {
+			return err
+		}
+	} else {
+		if !opts.Isolation.IsZero() {
+			return fmt.Errorf("isolation options are for the binary backend; runc plugins use --security-profile and --spec-patch")
+		}
+		if opts.BaseRootfs == "" {
+			opts.BaseRootfs = cfg.Runc.BaseRootfs
+		}
+		if opts.SecurityProfile == "" {
+			opts.SecurityProfile = cfg.Runc.SecurityProfile
+		}
+		if opts.SecurityProfile == "" {
+			opts.SecurityProfile = backend.SecurityDefault
+		}
+		switch opts.SecurityProfile {
+		case backend.SecurityDefault, backend.SecurityRestricted, backend.SecurityPrivileged:
+		default:
+			return fmt.Errorf("invalid security profile %q: want %s | %s | %s", opts.SecurityProfile,
+				backend.SecurityDefault, backend.SecurityRestricted, backend.SecurityPrivileged)
+		}
+		// A spec file is used verbatim: nothing the runtime would add to the generated spec reaches it.
+		if opts.SpecFile != "" && (len(opts.SpecPatches) > 0 || len(opts.Devices) > 0 || len(opts.Mounts) > 0 ||
+			opts.HostDir || len(opts.Secrets) > 0) {
+			return fmt.Errorf("--spec-file cannot be combined with --spec-patch, --device, --mount, --host-dir or secrets; put them in the spec file")
+		}
+		if opts.HostDir && opts.SecurityProfile == backend.SecurityRestricted {
+			return fmt.Errorf("--host-dir is not available with the restricted security profile")
+		}
+		if opts.Mounts, err = checkMounts(opts.Mounts, cfg.Runc.AllowedHostPaths); err != nil {
+			return err
+		}
+		opts.SpecOverride = cfg.Runc.SpecOverride
+		opts.AllowedHostPaths = cfg.Runc.AllowedHostPaths
+	}
+	if cfg.RuntimeSocket != "" && !filepath.IsAbs(cfg.RuntimeSocket) {
+		return fmt.Errorf("config.json runtime_socket %q must be an absolute path", cfg.RuntimeSocket)
+	}
+	opts.RuntimeSocket = cfg.RuntimeSocket
+	if opts.EnvPolicy == "" {
+		// What each backend always did: the agent's env for binary, a clean one in a container.
+		opts.EnvPolicy = backend.EnvInherit
+		if len(opts.EnvAllow) > 0 {
+			opts.EnvPolicy = backend.EnvAllowlist
+		} else if backendName == backend.BackendRunc {
+			opts.EnvPolicy = backend.EnvClean
+		}
+	}
+	if err := backend.ValidateEnvPolicy(opts.EnvPolicy, opts.EnvAllow); err != nil {
+		return err
+	}
+	// Verify before anything is registered or started, for both backends.
+	verified, err := r.verifyPlugin(cfg, opts.PluginID, opts.Image, opts.Executable, opts.Digest, opts.Signature)
+	if err != nil {
+		return err
+	}
+	// Only a pinned or signed run holds the backend to the verified bytes; an unpinned one runs
+	// whatever is at the path, as it would without verification.
+	if opts.Digest != "" || opts.Signature != "" {
+		opts.Digest = verified.Digest
+	}
+	runtimeStart, _ := state.ProcStart(os.Getpid())
 	meta := state.Meta{
-		PluginID:      opts.PluginID,
-		PluginVersion: opts.PluginVersion,
-		DeviceId:      opts.DeviceId,
-		HostType:      opts.HostType,
-		HostName:      opts.HostName,
-		Backend:       backendName,
-		RootDir:       r.rootDir,
-		WorkDir:       opts.WorkDir,
-		Executable:    opts.Executable,
-		Args:          opts.Args,
-		CPU:           opts.CPU,
-		Mem:           opts.Mem,
-		Env:           opts.Env,
-		RuntimePid:    os.Getpid(),
+		PluginID:        opts.PluginID,
+		PluginVersion:   opts.PluginVersion,
+		DeviceId:        opts.DeviceId,
+		HostType:        opts.HostType,
+		HostName:        opts.HostName,
+		Backend:         backendName,
+		RootDir:         r.rootDir,
+		WorkDir:         opts.WorkDir,
+		Executable:      opts.Executable,
+		Image:           opts.Image,
+		BaseRootfs:      opts.BaseRootfs,
+		SpecPatches:     opts.SpecPatches,
+		SpecFile:        opts.SpecFile,
+		SecurityProfile: opts.SecurityProfile,
+		Devices:         opts.Devices,
+		HostDir:         opts.HostDir,
+		Mounts:          opts.Mounts,
+		Secrets:         opts.Secrets,
+		Isolation:       opts.Isolation,
+		Args:            opts.Args,
+		Env:             opts.Env,
+		EnvFiles:        opts.EnvFiles,
+		EnvPolicy:       opts.EnvPolicy,
+		EnvAllow:        opts.EnvAllow,
+		RuntimePid:      os.Getpid(),
+		RuntimeStart:    runtimeStart,
+		Digest:          verified.Digest,
+		Signature:       opts.Signature,
+		SignedBy:        verified.KeyID,
+		Resources:       opts.Resources,
+		LogDrivers:      opts.LogDrivers,
+		LogParser:       opts.LogParser,
+		LogOpts:         opts.LogOpts,
+		LogRotation:     opts.LogRotation,
+		LogLimits:       opts.LogLimits,
+		Labels:          opts.Labels,
 	}
+	opts.StartedAt = time.Now().UTC()
+	meta.History = r.history(opts, verified.Digest)
 	if err := r.state.Register(meta); err != nil {
 		return err
 	}
-	return be.Run(ctx, opts)
+	if err := r.state.ClearStopRequest(opts.PluginID); err != nil {
+		return err
+	}
+	if err := r.state.ClearLogDropped(opts.PluginID); err != nil {
+		return err
+	}
+	log := logger.ForPlugin(r.log, opts.PluginID, backendName)
+	if err := be.Run(ctx, opts); err != nil {
+		log.Error("start plugin failed", zap.Error(err))
+		return err
+	}
+	log.Info("plugin started", zap.String("version", opts.PluginVersion))
+	return nil
+}
+
+// verifyPlugin checks the digest and signature of what a plugin runs against the trusted keys and
+// policy: the image manifest for --image plugins, the executable otherwise.
+func (r *Runtime) verifyPlugin(cfg *config.Config, pluginID, imageRef, path, digest, signature string) (verify.Result, error) {
+	vopts := verify.Options{
+		Digest:        digest,
+		Signature:     signature,
+		KeysDir:       filepath.Join(r.rootDir, verify.KeysDir),
+		RequireSigned: cfg.Verify.RequireSigned,
+	}
+	subject := path
+	var res verify.Result
+	var err error
+	if imageRef != "" {
+		subject = imageRef
+		var img *image.Image
+		if img, err = image.Resolve(imageRef); err == nil {
+			res, err = verify.Check(imageRef, img.Digest, vopts)
+		}
+	} else {
+		res, err = verify.Executable(path, vopts)
+	}
+	if err != nil {
+		r.pluginLog(pluginID).Error("verification failed", zap.String("subject", subject), zap.Error(err))
+		return res, fmt.Errorf("verify %s: %w", subject, err)
+	}
+	return res, nil
+}
+
+// history carries the version history over from a previous registration of the plugin, recording
+// digest as the started version's. A plain re-run of another version starts a new entry; upgrade
+// and rollback maintain the entries themselves.
+func (r *Runtime) history(opts backend.RunOptions, digest string) []backend.VersionRecord {
+	var history []backend.VersionRecord
+	if prev, err := r.state.LoadMeta(opts.PluginID); err == nil {
+		history = prev.History
+	}
+	if n := len(history); n > 0 && history[n-1].Version == opts.PluginVersion && history[n-1].Executable == opts.Executable {
+		history[n-1].Digest = digest
+		return history
+	}
+	for i := range history {
+		if history[i].Status == backend.VersionActive {
+			history[i].Status = backend.VersionSuperseded
+		}
+	}
+	return append(history, backend.VersionRecord{
+		Version:    opts.PluginVersion,
+		Executable: opts.Executable,
+		Digest:     digest,
+		Signature:  opts.Signature,
+		StartedAt:  opts.StartedAt,
+		Status:     backend.VersionActive,
+	})
+}
+
+// RunRegistered starts a registered plugin again from its meta and blocks like RunAndWait
+// (used by the shim that upgrade and rollback launch).
+func (r *Runtime) RunRegistered(ctx context.Context, pluginID string) error {
+	meta, err := r.state.LoadMeta(pluginID)
+	if err != nil {
+		return err
+	}
+	return r.RunAndWait(ctx, meta.Backend, optionsFromMeta(meta))
+}
+
+// optionsFromMeta rebuilds the RunOptions a plugin was registered with.
+func optionsFromMeta(meta *state.Meta) backend.RunOptions {
+	return backend.RunOptions{
+		PluginID:        meta.PluginID,
+		PluginVersion:   meta.PluginVersion,
+		DeviceId:        meta.DeviceId,
+		HostType:        meta.HostType,
+		HostName:        meta.HostName,
+		RootDir:         meta.RootDir,
+		WorkDir:         meta.WorkDir,
+		Executable:      meta.Executable,
+		Image:           meta.Image,
+		BaseRootfs:      meta.BaseRootfs,
+		SpecPatches:     meta.SpecPatches,
+		SpecFile:        meta.SpecFile,
+		SecurityProfile: meta.SecurityProfile,
+		Devices:         meta.Devices,
+		HostDir:         meta.HostDir,
+		Mounts:          meta.Mounts,
+		Secrets:         meta.Secrets,
+		Isolation:       meta.Isolation,
+		Args:            meta.Args,
+		Env:             meta.Env,
+		EnvFiles:        meta.EnvFiles,
+		EnvPolicy:       meta.EnvPolicy,
+		EnvAllow:        meta.EnvAllow,
+		Digest:          meta.Digest,
+		Signature:       meta.Signature,
+		Labels:          meta.Labels,
+		Resources:       meta.Resources,
+		LogRotation:     meta.LogRotation,
+		LogLimits:       meta.LogLimits,
+		LogParser:       meta.LogParser,
+		LogDrivers:      meta.LogDrivers,
+		LogOpts:         meta.LogOpts,
+	}
 }
 
 // RunAndWait starts the plugin and blocks until it exits or SIGTERM/SIGINT (used by the re-exec'd shim; keeps plugin as child, no orphan).
 func (r *Runtime) RunAndWait(ctx context.Context, backendName string, opts backend.RunOptions) error {
+	log := logger.ForPlugin(r.log, opts.PluginID, backendName)
+	// Once this process stops monitoring the plugin its pid means nothing; a later reuse of it must
+	// not be mistaken for the shim.
+	defer func() {
+		if err := r.state.ClearRuntimePid(opts.PluginID, os.Getpid()); err != nil && !os.IsNotExist(err) {
+			log.Warn("clear runtime pid failed", zap.Error(err))
+		}
+	}()
 	if err := r.Run(ctx, backendName, opts); err != nil {
 		return err
 	}
@@ -51,10 +283,19 @@ func (r *Runtime) RunAndWait(ctx context.Context, backendName string, opts backe
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 	ctx, cancel := context.WithCancel(ctx)
 	go func() {
-		<-sigCh
-		_ = r.state.RequestStop(opts.PluginID)
-		_ = be.Stop(ctx, opts.PluginID)
+		sig := <-sigCh
+		log.Info("stopping plugin", zap.Stringer("signal", sig))
+		r.requestStop(opts.PluginID)
+		if err := be.Stop(ctx, opts.PluginID); err != nil {
+			log.Error("stop plugin failed", zap.Error(err))
+		}
 		cancel()
 	}()
-	return be.Wait(ctx, opts.PluginID)
+	err := be.Wait(ctx, opts.PluginID)
+	if err != nil && ctx.Err() == nil {
+		log.Warn("plugin exited", zap.Error(err))
+	} else {
+		log.Info("plugin exited")
+	}
+	return err
 }
@@ -8,7 +8,10 @@ import (
 	"github.com/tomatopunk/agent-runtime/internal/backend"
 	"github.com/tomatopunk/agent-runtime/internal/backend/binary"
 	"github.com/tomatopunk/agent-runtime/internal/backend/runc"
+	"github.com/tomatopunk/agent-runtime/internal/labels"
+	"github.com/tomatopunk/agent-runtime/internal/logger"
 	"github.com/tomatopunk/agent-runtime/internal/state"
+	"go.uber.org/zap"
 )
 
 // Runtime is the unified facade: holds state and both backends, returns Backend by plugin or backend name.
@@ -17,16 +20,35 @@ type Runtime struct {
 	state   *state.Manager
 	binary  backend.Backend
 	runc    backend.Backend
+	log     *zap.Logger
 }
 
-// New creates a Runtime for the given rootDir.
+// New creates a Runtime for the given rootDir; it logs to the global zap logger.
 func New(rootDir string) *Runtime {
 	mgr := state.NewManager(rootDir)
+	log := zap.L()
 	return &Runtime{
 		rootDir: rootDir,
 		state:   mgr,
-		binary:  binary.New(mgr),
-		runc:    runc.New(mgr, ""),
+		binary:  binary.New(mgr, log),
+		runc:    runc.New(mgr, "", log),
+		log:     log,
+	}
+}
+
+// pluginLog returns the runtime logger with the plugin's fields; backend is looked up from meta if known.
+func (r *Runtime) pluginLog(pluginID string) *zap.Logger {
+	backendName := ""
+	if meta, err := r.state.LoadMeta(pluginID); err == nil {
+		backendName = meta.Backend
+	}
+	return logger.ForPlugin(r.log, pluginID, backendName)
+}
+
+// requestStop marks the plugin as stop-requested; failure is logged, the stop itself still proceeds.
+func (r *Runtime) requestStop(pluginID string) {
+	if err := r.state.RequestStop(pluginID); err != nil {
+		r.pluginLog(pluginID).Warn("request stop failed", zap.Error(err))
 	}
 }
 
@@ -55,7 +77,7 @@ func (r *Runtime) StateManager() *state.Manager { return r.state }
 
 // Stop requests stop and stops the plugin.
 func (r *Runtime) Stop(ctx context.Context, pluginID string) error {
-	_ = r.state.RequestStop(pluginID)
+	r.requestStop(pluginID)
 	be, err := r.BackendFor(pluginID)
 	if err != nil {
 		return err
@@ -65,7 +87,7 @@ func (r *Runtime) Stop(ctx context.Context, pluginID string) error {
 
 // Delete stops the plugin and cleans up.
 func (r *Runtime) Delete(ctx context.Context, pluginID string) error {
-	_ = r.state.RequestStop(pluginID)
+	r.requestStop(pluginID)
 	be, err := r.BackendFor(pluginID)
 	if err != nil {
 		return err
@@ -75,8 +97,14 @@ func (r *Runtime) Delete(ctx context.Context, pluginID string) error {
 
 // List returns all plugins; caller formats the output.
 func (r *Runtime) List(ctx context.Context) ([]backend.InstanceInfo, error) {
-	listB, _ := r.binary.List(ctx)
-	listR, _ := r.runc.List(ctx)
+	listB, err := r.binary.List(ctx)
+	if err != nil {
+		r.log.Warn("list binary plugins failed", zap.Error(err))
+	}
+	listR, err := r.runc.List(ctx)
+	if err != nil {
+		r.log.Warn("list runc plugins failed", zap.Error(err))
+	}
 	all := append(listB, listR...)
 	if all == nil {
 		all = []backend.InstanceInfo{}
@@ -90,7 +118,27 @@ func (r *Runtime) State(ctx context.Context, pluginID string) (*backend.StateInf
 	if err != nil {
 		return nil, err
 	}
-	return be.State(ctx, pluginID)
+	info, err := be.State(ctx, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	// Written by the shim's log pipeline, independent of the backend.
+	info.LogDroppedLines = r.state.ReadLogDropped(pluginID)
+	if meta, err := r.state.LoadMeta(pluginID); err == nil {
+		info.PluginVersion = meta.PluginVersion
+		info.Digest = meta.Digest
+		info.SignedBy = meta.SignedBy
+		info.History = meta.History
+		if meta.Isolation.Landlock != nil {
+			if abi, ok := r.state.ReadLandlockABI(pluginID); ok {
+				info.Landlock, info.LandlockABI = "enforced", abi
+				if abi == 0 {
+					info.Landlock = "unsupported"
+				}
+			}
+		}
+	}
+	return info, nil
 }
 
 // Log returns a Reader for the plugin log; caller copies to stdout.
@@ -102,19 +150,44 @@ func (r *Runtime) Log(ctx context.Context, pluginID string, opts backend.LogOpti
 	return be.Log(ctx, pluginID, opts)
 }
 
-// Destroy stops and removes all plugins.
-func (r *Runtime) Destroy(ctx context.Context) error {
+// Select returns the IDs of the plugins whose labels match sel; an empty selector matches all.
+func (r *Runtime) Select(sel labels.Selector) ([]string, error) {
 	ids, err := r.state.ListPluginIDs()
+	if err != nil {
+		return nil, err
+	}
+	if sel.Empty() {
+		return ids, nil
+	}
+	var out []string
+	for _, id := range ids {
+		meta, err := r.state.LoadMeta(id)
+		if err != nil {
+			continue
+		}
+		if sel.Matches(meta.Labels) {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// Destroy stops and removes all plugins matching sel (all plugins for an empty selector).
+func (r *Runtime) Destroy(ctx context.Context, sel labels.Selector) error {
+	ids, err := r.Select(sel)
 	if err != nil {
 		return err
 	}
 	for _, id := range ids {
-		_ = r.state.RequestStop(id)
+		r.requestStop(id)
 		be, err := r.BackendFor(id)
 		if err != nil {
+			r.pluginLog(id).Error("destroy: resolve backend failed", zap.Error(err))
 			continue
 		}
-		_ = be.Delete(ctx, id)
+		if err := be.Delete(ctx, id); err != nil {
+			r.pluginLog(id).Error("destroy: delete failed", zap.Error(err))
+		}
 	}
 	return nil
 }
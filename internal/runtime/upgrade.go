@@ -0,0 +1,518 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/config"
+	"github.com/tomatopunk/agent-runtime/internal/logger"
+	"github.com/tomatopunk/agent-runtime/internal/state"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReadyTimeout = 30 * time.Second
+	defaultMinUptime    = 5 * time.Second
+	stopTimeout         = 15 * time.Second
+	pollInterval        = 200 * time.Millisecond
+	snapshotExecutable  = "plugin"
+	snapshotRootfs      = "rootfs"
+	// A plugin's history keeps its newest maxHistory entries, and snapshots for rollback of the
+	// newest maxSnapshots versions besides the running one.
+	maxHistory   = 10
+	maxSnapshots = 3
+	// preflightSuffix names the instance that tries a new version before the running one is stopped.
+	preflightSuffix = "-upgrade"
+	// LabelPreflightOf marks a preflight instance with the plugin it is upgrading.
+	LabelPreflightOf = "agent-runtime.preflight-of"
+)
+
+// Launcher starts a detached shim that runs the registered plugin (see RunRegistered). The returned
+// channel receives the shim's exit error, or is closed when it exits cleanly.
+type Launcher func(pluginID string) (<-chan error, error)
+
+// UpgradeOptions configure a version switch.
+type UpgradeOptions struct {
+	Executable string // new executable (upgrade only)
+	Version    string // new version (upgrade), or the version to return to (rollback; empty = previous)
+	Digest     string // pins the new executable (upgrade only)
+	Signature  string // base64 ed25519 signature over the new executable's digest (upgrade only)
+	// HealthCmd, if set, must exit 0 for the new version to count as ready; it runs with PLUGIN_ID
+	// and PLUGIN_PID in its env. Without it, the plugin is ready once it has stayed up for MinUptime.
+	HealthCmd    []string
+	ReadyTimeout time.Duration
+	MinUptime    time.Duration
+	// NoPreflight skips trying the new version next to the running one before the switch (upgrade
+	// only), for plugins that cannot run twice at once, e.g. because they hold a device.
+	NoPreflight bool
+	Launch      Launcher
+}
+
+// Upgrade switches a plugin to a new executable and version. The new version is first started and
+// checked for readiness as a separate preflight instance while the running version keeps running,
+// so a build that cannot come up is refused without downtime. Then the running version's executable
+// (and, for runc, its rootfs) is kept under the versions dir and the plugin is switched over; if the
+// new version still does not become ready, the kept version is restored and started again.
+func (r *Runtime) Upgrade(ctx context.Context, pluginID string, opts UpgradeOptions) error {
+	if opts.Executable == "" || opts.Version == "" {
+		return fmt.Errorf("executable and version are required")
+	}
+	cfg, err := config.Load(r.rootDir)
+	if err != nil {
+		return err
+	}
+	// Refuse an unverifiable build before the running version is touched.
+	if _, err := r.verifyPlugin(cfg, pluginID, "", opts.Executable, opts.Digest, opts.Signature); err != nil {
+		return err
+	}
+	meta, err := r.state.LoadMeta(pluginID)
+	if err != nil {
+		return err
+	}
+	if meta.Image != "" {
+		return fmt.Errorf("plugin %s runs image %s; upgrade replaces executables only, run it again with the new image", pluginID, meta.Image)
+	}
+	if meta.PluginVersion == opts.Version && meta.Executable == opts.Executable {
+		return fmt.Errorf("plugin %s already runs version %s", pluginID, opts.Version)
+	}
+	next := backend.VersionRecord{Version: opts.Version, Executable: opts.Executable, Digest: opts.Digest, Signature: opts.Signature}
+	if !opts.NoPreflight {
+		if err := r.preflight(ctx, meta, next, opts); err != nil {
+			return fmt.Errorf("version %s did not pass preflight, %s keeps running: %w", opts.Version, meta.PluginVersion, err)
+		}
+	}
+	prev, err := r.keepCurrent(meta)
+	if err != nil {
+		return fmt.Errorf("keep current version: %w", err)
+	}
+	err = r.switchTo(ctx, meta, next, "", opts)
+	if err == nil {
+		return nil
+	}
+	log := r.pluginLog(pluginID)
+	log.Error("upgrade failed, rolling back", zap.String("version", opts.Version), zap.Error(err))
+	if rerr := r.restore(ctx, pluginID, prev, backend.VersionFailed, err, opts); rerr != nil {
+		return fmt.Errorf("upgrade to %s failed: %w; rollback to %s also failed: %v", opts.Version, err, prev.Version, rerr)
+	}
+	return fmt.Errorf("upgrade to %s failed, rolled back to %s: %w", opts.Version, prev.Version, err)
+}
+
+// preflight starts next as its own instance (ID <plugin>-upgrade, with its own work, data and log
+// dirs) next to the running version and waits for it to become ready. The instance is deleted
+// afterwards, whatever the outcome.
+func (r *Runtime) preflight(ctx context.Context, meta *state.Meta, next backend.VersionRecord, opts UpgradeOptions) error {
+	c := *meta
+	c.PluginID = meta.PluginID + preflightSuffix
+	c.WorkDir = filepath.Join(r.rootDir, "preflight", meta.PluginID)
+	c.PluginVersion, c.Executable, c.Digest, c.Signature = next.Version, next.Executable, next.Digest, next.Signature
+	c.Labels = map[string]string{LabelPreflightOf: meta.PluginID}
+	c.History = nil
+	if _, err := r.state.LoadMeta(c.PluginID); err == nil {
+		return fmt.Errorf("plugin %s exists; delete it to upgrade %s", c.PluginID, meta.PluginID)
+	}
+	if err := os.RemoveAll(c.WorkDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.WorkDir, 0700); err != nil {
+		return err
+	}
+	if err := r.state.Register(c); err != nil {
+		return err
+	}
+	log := logger.ForPlugin(r.log, c.PluginID, c.Backend)
+	log.Info("preflight", zap.String("version", next.Version), zap.String("executable", next.Executable))
+	exited, err := opts.Launch(c.PluginID)
+	if err == nil {
+		err = r.waitReady(ctx, c.PluginID, exited, opts)
+	}
+	// The shim re-registered the instance; stop it through what it recorded.
+	if cur, lerr := r.state.LoadMeta(c.PluginID); lerr == nil {
+		if serr := r.stopAndWait(ctx, cur); serr != nil {
+			log.Error("stop preflight instance failed", zap.Error(serr))
+		}
+	}
+	if derr := r.Delete(ctx, c.PluginID); derr != nil {
+		log.Warn("delete preflight instance failed", zap.Error(derr))
+	}
+	for _, dir := range []string{c.WorkDir, r.state.LogDir(c.PluginID)} {
+		if rerr := os.RemoveAll(dir); rerr != nil {
+			log.Warn("remove preflight dir failed", zap.String("dir", dir), zap.Error(rerr))
+		}
+	}
+	_ = os.Remove(filepath.Dir(c.WorkDir)) // only if no other upgrade is in preflight
+	return err
+}
+
+// Rollback switches a plugin back to a kept version: opts.Version, or the most recent superseded one.
+func (r *Runtime) Rollback(ctx context.Context, pluginID string, opts UpgradeOptions) error {
+	meta, err := r.state.LoadMeta(pluginID)
+	if err != nil {
+		return err
+	}
+	target := -1
+	for i := len(meta.History) - 1; i >= 0; i-- {
+		h := meta.History[i]
+		if h.Snapshot == "" || h.Status == backend.VersionActive || h.Status == backend.VersionFailed {
+			continue
+		}
+		if opts.Version == "" || h.Version == opts.Version {
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		if opts.Version != "" {
+			return fmt.Errorf("no kept version %s for plugin %s", opts.Version, pluginID)
+		}
+		return fmt.Errorf("no previous version kept for plugin %s", pluginID)
+	}
+	// Keep the current version too, so a rollback can itself be undone.
+	if _, err := r.keepCurrent(meta); err != nil {
+		return fmt.Errorf("keep current version: %w", err)
+	}
+	return r.restore(ctx, pluginID, meta.History[target], backend.VersionRolledBack, nil, opts)
+}
+
+// keepCurrent copies the active version's executable (and runc rootfs) into its version dir and
+// records the snapshot in meta. It returns the updated record.
+func (r *Runtime) keepCurrent(meta *state.Meta) (backend.VersionRecord, error) {
+	n := len(meta.History) - 1
+	for n >= 0 && meta.History[n].Status != backend.VersionActive {
+		n--
+	}
+	if n < 0 {
+		// Registered before version history existed, or the active entry was lost to a failed switch.
+		meta.History = append(meta.History, backend.VersionRecord{
+			Version:    meta.PluginVersion,
+			Executable: meta.Executable,
+			Digest:     meta.Digest,
+			Signature:  meta.Signature,
+			StartedAt:  time.Now().UTC(),
+			Status:     backend.VersionActive,
+		})
+		n = len(meta.History) - 1
+	}
+	rec := &meta.History[n]
+	if rec.Snapshot != "" {
+		return *rec, nil
+	}
+	dir, err := r.nextVersionDir(meta.PluginID)
+	if err != nil {
+		return *rec, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return *rec, err
+	}
+	if err := copyFile(meta.Executable, filepath.Join(dir, snapshotExecutable), 0755); err != nil {
+		return *rec, err
+	}
+	if meta.Backend == backend.BackendRunc && meta.BaseRootfs == "" {
+		if err := copyTree(filepath.Join(meta.WorkDir, snapshotRootfs), filepath.Join(dir, snapshotRootfs)); err != nil {
+			return *rec, err
+		}
+	}
+	rec.Snapshot = dir
+	return *rec, r.state.Register(*meta)
+}
+
+// nextVersionDir returns an unused version dir: one past the highest kept, so a dir is never
+// reused while history entries that were pruned or shifted may still point at it.
+func (r *Runtime) nextVersionDir(pluginID string) (string, error) {
+	entries, err := os.ReadDir(r.state.VersionsDir(pluginID))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	n := 0
+	for _, e := range entries {
+		if i, err := strconv.Atoi(e.Name()); err == nil && i >= n {
+			n = i + 1
+		}
+	}
+	return r.state.VersionDir(pluginID, n), nil
+}
+
+// pruneHistory bounds what a plugin keeps on the device: the newest maxHistory entries, and
+// snapshots for the newest maxSnapshots versions besides the active or pending one. It returns the
+// version dirs no remaining entry uses, for removal once meta is saved.
+func (r *Runtime) pruneHistory(meta *state.Meta) []string {
+	if n := len(meta.History) - maxHistory; n > 0 {
+		meta.History = meta.History[n:]
+	}
+	inUse := map[string]bool{}
+	kept := 0
+	for i := len(meta.History) - 1; i >= 0; i-- {
+		h := &meta.History[i]
+		if h.Snapshot == "" {
+			continue
+		}
+		if h.Status != backend.VersionActive && h.Status != backend.VersionPending {
+			if kept++; kept > maxSnapshots {
+				h.Snapshot = ""
+				continue
+			}
+		}
+		inUse[filepath.Clean(h.Snapshot)] = true
+	}
+	entries, _ := os.ReadDir(r.state.VersionsDir(meta.PluginID))
+	var unused []string
+	for _, e := range entries {
+		if dir := filepath.Join(r.state.VersionsDir(meta.PluginID), e.Name()); !inUse[dir] {
+			unused = append(unused, dir)
+		}
+	}
+	return unused
+}
+
+// restore switches back to a kept version; the version being replaced is marked with status.
+func (r *Runtime) restore(ctx context.Context, pluginID string, to backend.VersionRecord, status string, cause error, opts UpgradeOptions) error {
+	meta, err := r.state.LoadMeta(pluginID)
+	if err != nil {
+		return err
+	}
+	// The entry being replaced; if the switch failed before it was recorded, that is to itself.
+	if n := len(meta.History) - 1; n >= 0 && meta.History[n].Snapshot != to.Snapshot {
+		meta.History[n].Status = status
+		if cause != nil {
+			meta.History[n].Error = cause.Error()
+		}
+	}
+	rec := backend.VersionRecord{
+		Version:    to.Version,
+		Executable: filepath.Join(to.Snapshot, snapshotExecutable),
+		Digest:     to.Digest,
+		Signature:  to.Signature,
+		Snapshot:   to.Snapshot,
+	}
+	rootfs := ""
+	// A rootfs overlaid on a base rootfs is rebuilt from the base and the executable on start.
+	if meta.Backend == backend.BackendRunc && meta.BaseRootfs == "" {
+		rootfs = filepath.Join(to.Snapshot, snapshotRootfs)
+	}
+	return r.switchTo(ctx, meta, rec, rootfs, opts)
+}
+
+// switchTo stops the running version, registers next as pending and starts it through opts.Launch,
+// then waits for it to become ready. If rootfs is set, the runc bundle's rootfs is replaced with it.
+func (r *Runtime) switchTo(ctx context.Context, meta *state.Meta, next backend.VersionRecord, rootfs string, opts UpgradeOptions) error {
+	log := logger.ForPlugin(r.log, meta.PluginID, meta.Backend)
+	if err := r.stopAndWait(ctx, meta); err != nil {
+		return err
+	}
+	if rootfs != "" {
+		live := filepath.Join(meta.WorkDir, snapshotRootfs)
+		if err := os.RemoveAll(live); err != nil {
+			return err
+		}
+		if err := copyTree(rootfs, live); err != nil {
+			return fmt.Errorf("restore rootfs: %w", err)
+		}
+	}
+	for i := range meta.History {
+		if meta.History[i].Status == backend.VersionActive {
+			meta.History[i].Status = backend.VersionSuperseded
+		}
+	}
+	next.StartedAt = time.Now().UTC()
+	next.Status = backend.VersionPending
+	meta.History = append(meta.History, next)
+	meta.PluginVersion = next.Version
+	meta.Executable = next.Executable
+	// The new shim verifies these before it starts the plugin.
+	meta.Digest = next.Digest
+	meta.Signature = next.Signature
+	if err := r.state.Register(*meta); err != nil {
+		return err
+	}
+	log.Info("switching version", zap.String("version", next.Version), zap.String("executable", next.Executable))
+	exited, err := opts.Launch(meta.PluginID)
+	if err == nil {
+		err = r.waitReady(ctx, meta.PluginID, exited, opts)
+	}
+	// The new shim re-registered meta when it started; update the entry it carried over.
+	if cur, lerr := r.state.LoadMeta(meta.PluginID); lerr == nil {
+		meta = cur
+	}
+	status := backend.VersionActive
+	if err != nil {
+		status = backend.VersionFailed
+		// Leave nothing of the failed version running.
+		if serr := r.stopAndWait(ctx, meta); serr != nil {
+			log.Error("stop failed version", zap.Error(serr))
+		}
+	}
+	if n := len(meta.History) - 1; n >= 0 {
+		meta.History[n].Status = status
+		if err != nil {
+			meta.History[n].Error = err.Error()
+		}
+	}
+	var unused []string
+	if err == nil {
+		// A failed switch is followed by a restore, which must still find its snapshot.
+		unused = r.pruneHistory(meta)
+	}
+	if rerr := r.state.Register(*meta); rerr != nil && err == nil {
+		err = rerr
+	}
+	if err == nil {
+		for _, dir := range unused {
+			if rerr := os.RemoveAll(dir); rerr != nil {
+				log.Warn("remove pruned version failed", zap.String("dir", dir), zap.Error(rerr))
+			}
+		}
+	}
+	if err == nil {
+		log.Info("version ready", zap.String("version", next.Version))
+	}
+	return err
+}
+
+// stopAndWait stops the plugin and waits until both the plugin and the shim that ran it have exited,
+// killing them if they outlive stopTimeout. A pid is only waited for and killed while it is still
+// the process recorded at start (see state.SameProcess): after a crash or reboot it may belong to
+// an unrelated process.
+func (r *Runtime) stopAndWait(ctx context.Context, meta *state.Meta) error {
+	if err := r.Stop(ctx, meta.PluginID); err != nil {
+		return err
+	}
+	log := r.pluginLog(meta.PluginID)
+	pid, start, _ := r.state.ReadPidStart(meta.PluginID)
+	for _, p := range []struct {
+		pid   int
+		start uint64
+	}{{pid, start}, {meta.RuntimePid, meta.RuntimeStart}} {
+		if p.pid <= 0 || p.pid == os.Getpid() || !state.SameProcess(p.pid, p.start) {
+			continue
+		}
+		if !waitExit(ctx, p.pid, p.start, stopTimeout) {
+			log.Warn("process did not exit after stop, killing", zap.Int("pid", p.pid))
+			if err := syscall.Kill(p.pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return err
+			}
+			waitExit(ctx, p.pid, p.start, stopTimeout)
+		}
+	}
+	meta.RuntimePid, meta.RuntimeStart = 0, 0
+	return nil
+}
+
+// waitReady waits until the started version is ready: HealthCmd succeeds, or (without one) the
+// plugin has been running for MinUptime. It fails if the shim exits or the plugin dies first.
+func (r *Runtime) waitReady(ctx context.Context, pluginID string, exited <-chan error, opts UpgradeOptions) error {
+	timeout, minUptime := opts.ReadyTimeout, opts.MinUptime
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+	if minUptime <= 0 {
+		minUptime = defaultMinUptime
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var runningSince time.Time
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				return errors.New("shim exited before the plugin was ready")
+			}
+			return fmt.Errorf("shim exited before the plugin was ready: %w", err)
+		case <-ctx.Done():
+			return fmt.Errorf("plugin not ready after %s", timeout)
+		case <-t.C:
+		}
+		info, err := r.State(ctx, pluginID)
+		if err != nil || info.Status != "running" {
+			if !runningSince.IsZero() {
+				return errors.New("plugin exited before it was ready")
+			}
+			continue
+		}
+		if runningSince.IsZero() {
+			runningSince = time.Now()
+		}
+		if len(opts.HealthCmd) > 0 {
+			if healthy(ctx, opts.HealthCmd, pluginID, info.Pid) {
+				return nil
+			}
+			continue
+		}
+		if time.Since(runningSince) >= minUptime {
+			return nil
+		}
+	}
+}
+
+// healthy runs the health command once.
+func healthy(ctx context.Context, argv []string, pluginID string, pid int) bool {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "PLUGIN_ID="+pluginID, fmt.Sprintf("PLUGIN_PID=%d", pid))
+	return cmd.Run() == nil
+}
+
+// waitExit polls until pid is gone or no longer the process that had start time start; it reports
+// false on timeout.
+func waitExit(ctx context.Context, pid int, start uint64, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		if !state.SameProcess(pid, start) {
+			return true
+		}
+		time.Sleep(pollInterval)
+	}
+	return false
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyTree copies a directory tree, keeping modes and symlinks; other special files are skipped.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			return copyFile(path, target, fi.Mode().Perm())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+)
+
+// Volume is a named volume under <root>/volumes.
+type Volume struct {
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`
+	UsedBy []string `json:"used_by,omitempty"` // registered plugins that mount it
+}
+
+// checkMounts validates the mounts and that every bind source lies at or below an allowed host path
+// (see backend.AllowedSource). The mounts returned carry the resolved sources.
+func checkMounts(mounts []backend.Mount, allowed []string) ([]backend.Mount, error) {
+	out := make([]backend.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		if m.Type == backend.MountBind {
+			src, err := backend.AllowedSource(m.Source, allowed)
+			if err != nil {
+				return nil, err
+			}
+			m.Source = src
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Volumes lists the named volumes and the plugins using them.
+func (r *Runtime) Volumes() ([]Volume, error) {
+	entries, err := os.ReadDir(r.state.VolumesDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	users, err := r.volumeUsers()
+	if err != nil {
+		return nil, err
+	}
+	var out []Volume
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		out = append(out, Volume{Name: e.Name(), Path: r.state.VolumeDir(e.Name()), UsedBy: users[e.Name()]})
+	}
+	return out, nil
+}
+
+// RemoveVolume deletes a named volume and its data; a volume still mounted by a registered plugin
+// is refused.
+func (r *Runtime) RemoveVolume(name string) error {
+	if err := backend.ValidateVolumeName(name); err != nil {
+		return err
+	}
+	dir := r.state.VolumeDir(name)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no volume %q", name)
+		}
+		return err
+	}
+	users, err := r.volumeUsers()
+	if err != nil {
+		return err
+	}
+	if len(users[name]) > 0 {
+		return fmt.Errorf("volume %q is used by %s; delete those plugins first", name, strings.Join(users[name], ", "))
+	}
+	return os.RemoveAll(dir)
+}
+
+// volumeUsers maps volume names to the registered plugins that mount them.
+func (r *Runtime) volumeUsers() (map[string][]string, error) {
+	ids, err := r.state.ListPluginIDs()
+	if err != nil {
+		return nil, err
+	}
+	users := map[string][]string{}
+	for _, id := range ids {
+		meta, err := r.state.LoadMeta(id)
+		if err != nil {
+			continue
+		}
+		for _, m := range meta.Mounts {
+			if u := users[m.Source]; m.Type == backend.MountVolume && (len(u) == 0 || u[len(u)-1] != id) {
+				users[m.Source] = append(u, id)
+			}
+		}
+	}
+	for _, u := range users {
+		sort.Strings(u)
+	}
+	return users, nil
+}
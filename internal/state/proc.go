@@ -0,0 +1,38 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ProcStart returns the start time of process pid (clock ticks after boot, /proc/<pid>/stat field
+// 22). With the pid it identifies a process: a pid reused after the process exited, or after a
+// reboot, has another start time.
+func ProcStart(pid int) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name (field 2) may hold spaces and parentheses; the fields after it do not.
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("parse /proc/%d/stat", pid)
+	}
+	fields := bytes.Fields(b[i+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("parse /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(string(fields[19]), 10, 64)
+}
+
+// SameProcess reports whether pid is still the process that had start time start. An unknown
+// start (0, from state written before it was recorded) never matches.
+func SameProcess(pid int, start uint64) bool {
+	if pid <= 0 || start == 0 {
+		return false
+	}
+	cur, err := ProcStart(pid)
+	return err == nil && cur == start
+}
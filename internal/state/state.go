@@ -6,30 +6,62 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+
+	"github.com/tomatopunk/agent-runtime/internal/backend"
+	"github.com/tomatopunk/agent-runtime/internal/resources"
 )
 
 const (
 	StopRequestedFile = "stop_requested"
 	MetaFile          = "meta.json"
 	PidFile           = "pid"
+	LogDroppedFile    = "log_dropped"
+	LandlockFile      = "landlock_abi"
 )
 
 // Meta is the metadata for each plugin under the state dir.
 type Meta struct {
-	PluginID      string   `json:"plugin_id"`
-	PluginVersion string   `json:"plugin_version,omitempty"`
-	DeviceId      string   `json:"device_id,omitempty"`
-	HostType      string   `json:"host_type,omitempty"`
-	HostName      string   `json:"host_name,omitempty"`
-	Backend       string   `json:"backend"`
-	RootDir       string   `json:"root_dir"`
-	WorkDir       string   `json:"work_dir"`
-	Executable    string   `json:"executable"` // host path to binary
-	Args          []string `json:"args,omitempty"`
-	CPU           string   `json:"cpu"`
-	Mem           string   `json:"mem"`
-	Env           []string `json:"env,omitempty"`
-	RuntimePid    int      `json:"runtime_pid"` // pid of the runtime process that monitors this plugin
+	PluginID        string   `json:"plugin_id"`
+	PluginVersion   string   `json:"plugin_version,omitempty"`
+	DeviceId        string   `json:"device_id,omitempty"`
+	HostType        string   `json:"host_type,omitempty"`
+	HostName        string   `json:"host_name,omitempty"`
+	Backend         string   `json:"backend"`
+	RootDir         string   `json:"root_dir"`
+	WorkDir         string   `json:"work_dir"`
+	Executable      string   `json:"executable"`      // host path to binary
+	Image           string   `json:"image,omitempty"` // oci-layout:<dir>[:<tag>] (runc only)
+	BaseRootfs      string   `json:"base_rootfs,omitempty"`
+	SpecPatches     []string `json:"spec_patches,omitempty"`
+	SpecFile        string   `json:"spec_file,omitempty"`
+	SecurityProfile string   `json:"security_profile,omitempty"`
+	Devices         []string `json:"devices,omitempty"`
+	HostDir         bool     `json:"host_dir,omitempty"`
+	Args            []string `json:"args,omitempty"`
+	Env             []string `json:"env,omitempty"`
+	EnvFiles        []string `json:"env_files,omitempty"`
+	EnvPolicy       string   `json:"env_policy,omitempty"`
+	EnvAllow        []string `json:"env_allow,omitempty"`
+	RuntimePid      int      `json:"runtime_pid"`             // pid of the runtime process that monitors this plugin
+	RuntimeStart    uint64   `json:"runtime_start,omitempty"` // RuntimePid's start time (ProcStart), to tell a reused pid apart
+	Digest          string   `json:"digest,omitempty"`        // verified sha256 of Executable
+	Signature       string   `json:"signature,omitempty"`     // base64 ed25519 signature over Digest
+	SignedBy        string   `json:"signed_by,omitempty"`     // trusted key ID that verified Signature
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Resources   resources.Options   `json:"resources,omitempty"`
+	Isolation   backend.Isolation   `json:"isolation,omitzero"`
+	Mounts      []backend.Mount     `json:"mounts,omitempty"`
+	Secrets     []backend.Secret    `json:"secrets,omitempty"` // references only, never values
+	LogDrivers  []string            `json:"log_drivers,omitempty"`
+	LogParser   string              `json:"log_parser,omitempty"`
+	LogOpts     map[string]string   `json:"log_opts,omitempty"`
+	LogRotation backend.LogRotation `json:"log_rotation,omitempty"`
+	LogLimits   backend.LogLimits   `json:"log_limits,omitempty"`
+
+	// History is the plugin's version history, oldest first; it survives re-runs of the same plugin ID.
+	History []backend.VersionRecord `json:"history,omitempty"`
 }
 
 // Manager manages the state dir: registration, stop requests, enumeration.
@@ -50,23 +82,89 @@ func (m *Manager) PluginDir(pluginID string) string {
 	return filepath.Join(m.StateDir(), pluginID)
 }
 
+// VersionsDir returns the dir holding the plugin's kept versions.
+func (m *Manager) VersionsDir(pluginID string) string {
+	return filepath.Join(m.rootDir, "versions", pluginID)
+}
+
+// VersionDir returns the dir where upgrade keeps the n-th kept version's executable (and runc rootfs).
+func (m *Manager) VersionDir(pluginID string, n int) string {
+	return filepath.Join(m.VersionsDir(pluginID), fmt.Sprintf("%d", n))
+}
+
+// SnapshotsDir returns the dir holding unpacked image rootfs snapshots, shared by all plugins.
+func (m *Manager) SnapshotsDir() string {
+	return filepath.Join(m.rootDir, "images", "snapshots")
+}
+
+// VolumesDir returns the dir holding named volumes; they are never removed with a plugin.
+func (m *Manager) VolumesDir() string {
+	return filepath.Join(m.rootDir, "volumes")
+}
+
+// VolumeDir returns the dir of the named volume.
+func (m *Manager) VolumeDir(name string) string {
+	return filepath.Join(m.VolumesDir(), name)
+}
+
+// ExecDir returns the dir holding the binary backend's private copy of the plugin executable, the
+// one it actually runs.
+func (m *Manager) ExecDir(pluginID string) string {
+	return filepath.Join(m.rootDir, "exec", pluginID)
+}
+
+// DataDir returns the plugin's persistent data dir (PLUGIN_DATA_DIR); it survives restarts,
+// upgrades and rollbacks and is removed with the plugin.
+func (m *Manager) DataDir(pluginID string) string {
+	return filepath.Join(m.rootDir, "data", pluginID)
+}
+
+// LogDir returns the plugin's log dir (<root>/logs/<plugin-id>).
+func (m *Manager) LogDir(pluginID string) string {
+	return filepath.Join(m.rootDir, "logs", pluginID)
+}
+
+// LogPath returns the live log file written by the file log driver.
+func (m *Manager) LogPath(pluginID string) string {
+	return filepath.Join(m.LogDir(pluginID), "stdout.log")
+}
+
+// PluginLogDir returns the dir the plugin may write its own log files to (PLUGIN_LOG_DIR), next to
+// the log the shim writes for it.
+func (m *Manager) PluginLogDir(pluginID string) string {
+	return filepath.Join(m.LogDir(pluginID), "plugin")
+}
+
+// LogSocket returns the unix socket on which the shim serves the ring log driver.
+func (m *Manager) LogSocket(pluginID string) string {
+	return filepath.Join(m.PluginDir(pluginID), "log.sock")
+}
+
 func (m *Manager) EnsureStateDir() error {
 	return os.MkdirAll(m.StateDir(), 0755)
 }
 
-// Register creates the plugin dir under state and writes meta.
+// Register creates the plugin dir under state and writes meta. Both are private to the owner (meta
+// holds the plugin's env); dirs and files from older runtimes are tightened on the way.
 func (m *Manager) Register(meta Meta) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	dir := m.PluginDir(meta.PluginID)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
 		return err
 	}
 	b, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, MetaFile), b, 0644)
+	path := filepath.Join(dir, MetaFile)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
 }
 
 // RequestStop writes a stop request file; the monitor process will detect it and exit.
@@ -93,6 +191,15 @@ func (m *Manager) LoadMeta(pluginID string) (*Meta, error) {
 	if err := json.Unmarshal(b, &meta); err != nil {
 		return nil, err
 	}
+	// Plugins registered before resources.Options kept cpu and mem at the top level.
+	var legacy struct {
+		CPU       string          `json:"cpu"`
+		Mem       string          `json:"mem"`
+		Resources json.RawMessage `json:"resources"`
+	}
+	if err := json.Unmarshal(b, &legacy); err == nil && legacy.Resources == nil {
+		meta.Resources = resources.LegacyOptions(legacy.CPU, legacy.Mem)
+	}
 	return &meta, nil
 }
 
@@ -117,25 +224,116 @@ func (m *Manager) ListPluginIDs() ([]string, error) {
 	return ids, nil
 }
 
-// Remove removes the plugin state dir (used by delete).
+// Remove removes the plugin state dir, its kept versions, its executable copy and its data dir
+// (used by delete).
 func (m *Manager) Remove(pluginID string) error {
+	for _, dir := range []string{m.VersionsDir(pluginID), m.ExecDir(pluginID), m.DataDir(pluginID)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
 	return os.RemoveAll(m.PluginDir(pluginID))
 }
 
-// WritePid writes the plugin process pid (used by binary backend).
+// ClearStopRequest removes a stop request left by a previous stop, so a restarted plugin is not seen as stopping.
+func (m *Manager) ClearStopRequest(pluginID string) error {
+	err := os.Remove(filepath.Join(m.PluginDir(pluginID), StopRequestedFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ClearLogDropped resets the dropped-lines counter for a new instance of the plugin.
+func (m *Manager) ClearLogDropped(pluginID string) error {
+	err := os.Remove(filepath.Join(m.PluginDir(pluginID), LogDroppedFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// WritePid writes the plugin process pid (used by binary backend), followed by its start time (see
+// ProcStart) when it can be read.
 func (m *Manager) WritePid(pluginID string, pid int) error {
 	path := filepath.Join(m.PluginDir(pluginID), PidFile)
-	return os.WriteFile(path, []byte(fmt.Sprintf("%d", pid)), 0644)
+	start, _ := ProcStart(pid)
+	return os.WriteFile(path, []byte(fmt.Sprintf("%d %d", pid, start)), 0644)
 }
 
 // ReadPid reads the pid file (numeric string).
 func (m *Manager) ReadPid(pluginID string) (int, error) {
+	pid, _, err := m.ReadPidStart(pluginID)
+	return pid, err
+}
+
+// ReadPidStart reads the pid file with the recorded start time; the start is 0 in a file written
+// before it was recorded.
+func (m *Manager) ReadPidStart(pluginID string) (int, uint64, error) {
 	path := filepath.Join(m.PluginDir(pluginID), PidFile)
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	var pid int
-	_, _ = fmt.Sscanf(string(b), "%d", &pid)
-	return pid, nil
+	var start uint64
+	_, _ = fmt.Sscanf(string(b), "%d %d", &pid, &start)
+	return pid, start, nil
+}
+
+// RemovePid removes the pid file once the plugin process has exited.
+func (m *Manager) RemovePid(pluginID string) error {
+	err := os.Remove(filepath.Join(m.PluginDir(pluginID), PidFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ClearRuntimePid clears the meta's RuntimePid once the runtime process runtimePid stops monitoring
+// the plugin; a later start that took over is left alone.
+func (m *Manager) ClearRuntimePid(pluginID string, runtimePid int) error {
+	meta, err := m.LoadMeta(pluginID)
+	if err != nil {
+		return err
+	}
+	if meta.RuntimePid != runtimePid {
+		return nil
+	}
+	meta.RuntimePid, meta.RuntimeStart = 0, 0
+	return m.Register(*meta)
+}
+
+// WriteLogDropped records the number of log lines dropped by the rate limit (written by the shim).
+func (m *Manager) WriteLogDropped(pluginID string, n int64) error {
+	path := filepath.Join(m.PluginDir(pluginID), LogDroppedFile)
+	return os.WriteFile(path, []byte(fmt.Sprintf("%d", n)), 0644)
+}
+
+// WriteLandlockABI records the Landlock ABI applied to the plugin; 0 means the kernel had none.
+func (m *Manager) WriteLandlockABI(pluginID string, abi int) error {
+	path := filepath.Join(m.PluginDir(pluginID), LandlockFile)
+	return os.WriteFile(path, []byte(fmt.Sprintf("%d", abi)), 0644)
+}
+
+// ReadLandlockABI reads the recorded Landlock ABI; ok is false if none was recorded.
+func (m *Manager) ReadLandlockABI(pluginID string) (abi int, ok bool) {
+	b, err := os.ReadFile(filepath.Join(m.PluginDir(pluginID), LandlockFile))
+	if err != nil {
+		return 0, false
+	}
+	_, err = fmt.Sscanf(string(b), "%d", &abi)
+	return abi, err == nil
+}
+
+// ReadLogDropped reads the dropped-lines counter; a missing file means none were dropped.
+func (m *Manager) ReadLogDropped(pluginID string) int64 {
+	path := filepath.Join(m.PluginDir(pluginID), LogDroppedFile)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	var n int64
+	_, _ = fmt.Sscanf(string(b), "%d", &n)
+	return n
 }
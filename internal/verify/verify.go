@@ -0,0 +1,196 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeysDir is the dir under the runtime root holding trusted ed25519 public keys, one per <name>.pub
+// file (PEM "PUBLIC KEY" or base64 of the 32 raw bytes). The file name is the key ID.
+const KeysDir = "trusted-keys"
+
+const digestPrefix = "sha256:"
+
+// ErrUnsigned is returned when policy requires a signature and none was given.
+var ErrUnsigned = errors.New("unsigned plugins are refused by policy (require_signed)")
+
+// Options say what to check about an executable or image.
+type Options struct {
+	Digest        string // expected "sha256:<hex>"; empty = not pinned
+	Signature     string // base64 ed25519 signature over the digest string; empty = unsigned
+	KeysDir       string
+	RequireSigned bool
+}
+
+// Result is what was verified.
+type Result struct {
+	Digest string // "sha256:<hex>" of the executable (or image manifest)
+	KeyID  string // trusted key that signed it; empty if unsigned
+}
+
+// Executable hashes path and checks it against opts. The signature covers the digest string
+// ("sha256:<hex>"), so signing does not need the whole binary in memory.
+func Executable(path string, opts Options) (Result, error) {
+	if opts.Digest != "" {
+		if err := ValidateDigest(opts.Digest); err != nil {
+			return Result{}, err
+		}
+	}
+	if opts.Signature == "" && opts.RequireSigned {
+		return Result{}, ErrUnsigned
+	}
+	digest, err := Digest(path)
+	if err != nil {
+		return Result{}, err
+	}
+	return Check(path, digest, opts)
+}
+
+// Check verifies an already computed digest of subject (an executable path or an image reference)
+// against opts.
+func Check(subject, digest string, opts Options) (Result, error) {
+	res := Result{Digest: digest}
+	if opts.Digest != "" {
+		if err := ValidateDigest(opts.Digest); err != nil {
+			return res, err
+		}
+		if !strings.EqualFold(opts.Digest, digest) {
+			return res, fmt.Errorf("digest mismatch for %s: want %s, got %s", subject, opts.Digest, digest)
+		}
+	}
+	if opts.Signature == "" && opts.RequireSigned {
+		return res, ErrUnsigned
+	}
+	if opts.Signature == "" {
+		return res, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(opts.Signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return res, fmt.Errorf("invalid signature: want base64 of %d bytes", ed25519.SignatureSize)
+	}
+	keys, err := LoadKeys(opts.KeysDir)
+	if err != nil {
+		return res, err
+	}
+	if len(keys) == 0 {
+		return res, fmt.Errorf("no trusted keys in %s", opts.KeysDir)
+	}
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if ed25519.Verify(keys[id], []byte(digest), sig) {
+			res.KeyID = id
+			return res, nil
+		}
+	}
+	return res, fmt.Errorf("signature for %s does not match any trusted key", subject)
+}
+
+// Digest returns "sha256:<hex>" of the file at path.
+func Digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return DigestOf(f)
+}
+
+// DigestOf returns "sha256:<hex>" of everything read from r.
+func DigestOf(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return digestPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ValidateDigest reports whether d has the form sha256:<64 hex chars>.
+func ValidateDigest(d string) error {
+	hexPart, ok := strings.CutPrefix(d, digestPrefix)
+	if !ok || len(hexPart) != sha256.Size*2 {
+		return fmt.Errorf("invalid digest %q: want sha256:<64 hex chars>", d)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", d, err)
+	}
+	return nil
+}
+
+// ReadSignature reads a detached signature file: either the raw 64 bytes or their base64 text.
+// It returns the base64 form stored in meta.
+func ReadSignature(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == ed25519.SignatureSize {
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+	s := strings.TrimSpace(string(b))
+	if sig, err := base64.StdEncoding.DecodeString(s); err != nil || len(sig) != ed25519.SignatureSize {
+		return "", fmt.Errorf("invalid signature file %s: want %d raw bytes or their base64", path, ed25519.SignatureSize)
+	}
+	return s, nil
+}
+
+// LoadKeys reads the trusted public keys in dir; a missing dir means no keys.
+func LoadKeys(dir string) (map[string]ed25519.PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := map[string]ed25519.PublicKey{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".pub" {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %s: %w", path, err)
+		}
+		keys[strings.TrimSuffix(e.Name(), ".pub")] = key
+	}
+	return keys, nil
+}
+
+func parseKey(b []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an ed25519 key")
+		}
+		return key, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("want PEM PUBLIC KEY or base64 of %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
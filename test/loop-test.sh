@@ -1,5 +1,6 @@
 #!/usr/bin/env bash
 # 死循环，每 30 秒打印当前时间（用于 agent-runtime 调试）
+# runc 后端需要 --base-rootfs（如解压的 busybox）提供 date 和 sleep
 set -e
 while true; do
   echo "$(date -Iseconds 2>/dev/null || date)"